@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxConfigSize 镜像 config 的最大字节数，超过时不再读取
+const maxConfigSize = 4 << 20
+
+// imageCreated 在拉取前从仓库读取镜像 config 中的创建时间，多平台镜像按 platform 选择
+func (c *registryClient) imageCreated(ctx context.Context, image, platform string) (time.Time, error) {
+	host, repo, manifest, err := c.platformManifest(ctx, image, platform)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if manifest.Config.Digest == "" {
+		return time.Time{}, fmt.Errorf("manifest of %s has no config", image)
+	}
+	resp, err := c.do(ctx, http.MethodGet, host, c.endpoint(host)+"/v2/"+repo+"/blobs/"+manifest.Config.Digest.String(), nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("get config %s@%s: %s", repo, manifest.Config.Digest, resp.Status)
+	}
+	var config struct {
+		Created *time.Time `json:"created"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxConfigSize)).Decode(&config)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse config of %s: %v", image, err)
+	}
+	if config.Created == nil {
+		return time.Time{}, nil
+	}
+	return *config.Created, nil
+}
+
+// imageTooOld 判断创建于 created 的镜像是否超过 maxAge，known 为 false 时无法确定创建时间，
+// 如未记录创建时间或为了可重现构建设置为 1970-01-01
+func imageTooOld(created time.Time, maxAge time.Duration, now time.Time) (old, known bool) {
+	if created.Unix() <= 0 {
+		return false, false
+	}
+	return now.Sub(created) > maxAge, true
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImageCreated(t *testing.T) {
+	f := newFakeRegistry(t, true)
+	oldConfig := f.setBlob([]byte(`{"architecture":"amd64","os":"linux","created":"2020-01-02T03:04:05.123456789Z"}`))
+	freshConfig := f.setBlob([]byte(`{"architecture":"arm64","os":"linux","created":"2026-10-01T00:00:00Z"}`))
+	noCreated := f.setBlob([]byte(`{"architecture":"amd64","os":"linux"}`))
+	epoch := f.setBlob([]byte(`{"architecture":"amd64","os":"linux","created":"1970-01-01T00:00:00Z"}`))
+	amd64 := f.setManifest("library/app", "amd64", testManifest(oldConfig))
+	arm64 := f.setManifest("library/app", "arm64", testManifest(freshConfig))
+	f.setManifest("library/app", "1.0", map[string]interface{}{
+		"mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+		"manifests": []interface{}{
+			map[string]interface{}{"digest": amd64, "size": 1, "platform": map[string]string{"os": "linux", "architecture": "amd64"}},
+			map[string]interface{}{"digest": arm64, "size": 1, "platform": map[string]string{"os": "linux", "architecture": "arm64"}},
+		},
+	})
+	f.setManifest("library/reproducible", "1.0", testManifest(epoch))
+	f.setManifest("library/bare", "1.0", testManifest(noCreated))
+	const lost = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	f.setManifest("library/lost", "1.0", testManifest(lost))
+
+	rc := f.client()
+	tests := []struct {
+		image, platform string
+		want            time.Time
+		wantErr         string
+	}{
+		{f.host + "/library/app:amd64", "", time.Date(2020, 1, 2, 3, 4, 5, 123456789, time.UTC), ""},
+		{f.host + "/library/app:1.0", "linux/amd64", time.Date(2020, 1, 2, 3, 4, 5, 123456789, time.UTC), ""},
+		{f.host + "/library/app:1.0", "linux/arm64", time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), ""},
+		{f.host + "/library/reproducible:1.0", "", time.Unix(0, 0).UTC(), ""},
+		{f.host + "/library/bare:1.0", "", time.Time{}, ""},
+		{f.host + "/library/app:1.0", "linux/s390x", time.Time{}, "platform linux/s390x not available"},
+		{f.host + "/library/lost:1.0", "", time.Time{}, "404"},
+		{f.host + "/library/missing:1.0", "", time.Time{}, "404"},
+	}
+	for _, tt := range tests {
+		got, err := rc.imageCreated(context.Background(), tt.image, tt.platform)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("imageCreated(%s, %s) error = %v, want %q", tt.image, tt.platform, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("imageCreated(%s, %s) = %s, %v, want %s", tt.image, tt.platform, got, err, tt.want)
+		}
+	}
+
+	// 只读取 manifest 和 config，不下载镜像层
+	configs := map[string]bool{oldConfig: true, freshConfig: true, noCreated: true, epoch: true, lost: true}
+	for _, request := range f.requestLog() {
+		index := strings.Index(request, "/blobs/")
+		if index != -1 && !configs[request[index+len("/blobs/"):]] {
+			t.Errorf("imageCreated requested %s, want only config blobs", request)
+		}
+	}
+}
+
+func TestImageTooOld(t *testing.T) {
+	now := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	maxAge := 30 * 24 * time.Hour
+	tests := []struct {
+		name       string
+		created    time.Time
+		old, known bool
+	}{
+		{"fresh", now.Add(-24 * time.Hour), false, true},
+		{"at the limit", now.Add(-maxAge), false, true},
+		{"old", now.Add(-maxAge - time.Second), true, true},
+		{"years old", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), true, true},
+		{"reproducible build", time.Unix(0, 0), false, false},
+		{"not recorded", time.Time{}, false, false},
+	}
+	for _, tt := range tests {
+		old, known := imageTooOld(tt.created, maxAge, now)
+		if old != tt.old || known != tt.known {
+			t.Errorf("%s: imageTooOld(%s) = %v, %v, want %v, %v", tt.name, tt.created, old, known, tt.old, tt.known)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+)
+
+// readLines 读取每行一项的文件，忽略空行和 # 开头的注释
+func readLines(file string) []string {
+	f, err := os.Open(file)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	return scanLines(f)
+}
+
+// scanLines 逐行读取 r，忽略空行和 # 开头的注释
+func scanLines(r io.Reader) []string {
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		panic(err)
+	}
+	return lines
+}
+
+// allowed 判断源镜像是否匹配白名单中的任意一项
+func allowed(source string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matchImage(pattern, source) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchImage 分别按通配符匹配仓库和 tag，pattern 未指定 tag 时匹配所有 tag，指定 digest 时要求 digest 一致
+func matchImage(pattern, source string) bool {
+	patternName, patternDigest := splitDigest(pattern)
+	name, digest := splitDigest(source)
+	if patternDigest != "" && patternDigest != digest {
+		return false
+	}
+	patternRepo, patternTag := splitTag(patternName)
+	repo, tag := splitTag(name)
+	if tag == "" && digest == "" {
+		tag = "latest"
+	}
+	if patternTag != "" {
+		if ok, _ := path.Match(patternTag, tag); !ok {
+			return false
+		}
+	}
+	if ok, _ := path.Match(patternRepo, repo); ok {
+		return true
+	}
+	// 同时按完整名称匹配，如 nginx 与 docker.io/library/nginx，不含通配符的 pattern 也按完整名称比较
+	named, err := reference.ParseNormalizedNamed(repo)
+	if err != nil {
+		return false
+	}
+	if ok, _ := path.Match(patternRepo, named.Name()); ok {
+		return true
+	}
+	if strings.ContainsAny(patternRepo, "*?[") {
+		return false
+	}
+	patternNamed, err := reference.ParseNormalizedNamed(patternRepo)
+	return err == nil && patternNamed.Name() == named.Name()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchImage(t *testing.T) {
+	tests := []struct {
+		pattern, source string
+		want            bool
+	}{
+		{"nginx", "nginx", true},
+		{"nginx", "nginx:1.25", true},
+		{"nginx", "docker.io/library/nginx:1", true},
+		{"docker.io/library/nginx", "nginx:1.25", true},
+		{"library/nginx", "nginx", true},
+		{"nginx", "redis", false},
+		{"nginx", "user/nginx", false},
+		{"nginx:1.*", "nginx:1.25", true},
+		{"nginx:1.*", "nginx:2.0", false},
+		{"nginx:latest", "nginx", true},
+		{"nginx:latest", "nginx@" + testDigest, false},
+		{"gcr.io/*/pause", "gcr.io/google-containers/pause:3.9", true},
+		{"*/pause", "gcr.io/google-containers/pause:3.9", false},
+		{"docker.io/library/*", "nginx:1.25", true},
+		{"nginx@" + testDigest, "nginx@" + testDigest, true},
+		{"nginx@" + testDigest, "nginx:1.25", false},
+		{"localhost:5000/app", "localhost:5000/app:v1", true},
+		{"localhost:5000/app:v*", "localhost:5000/app:v1", true},
+	}
+	for _, tt := range tests {
+		if got := matchImage(tt.pattern, tt.source); got != tt.want {
+			t.Errorf("matchImage(%q, %q) = %v, want %v", tt.pattern, tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestScanLines(t *testing.T) {
+	got := scanLines(strings.NewReader("nginx\n\n  # comment\n  redis:7  \r\n#nginx\n"))
+	want := []string{"nginx", "redis:7"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("scanLines = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"gopkg.in/yaml.v3"
+)
+
+// dockerHubHost Docker Hub 的仓库地址
+const dockerHubHost = "docker.io"
+
+// registryCredential 单个镜像仓库的认证信息
+type registryCredential struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Token 直接作为 Bearer Token 使用，设置后可不填用户名密码
+	Token string `yaml:"token"`
+}
+
+// String 打印时隐藏密码和 token
+func (c registryCredential) String() string {
+	if c.Password == "" && c.Token == "" {
+		return c.Username
+	}
+	return c.Username + ":******"
+}
+
+// authConfig 转换为 Docker 认证配置
+func (c registryCredential) authConfig(host string) types.AuthConfig {
+	return types.AuthConfig{
+		Username:      c.Username,
+		Password:      c.Password,
+		RegistryToken: c.Token,
+		ServerAddress: host,
+	}
+}
+
+// encodeAuth 编码为 Docker API 使用的 X-Registry-Auth
+func encodeAuth(authConfig types.AuthConfig) string {
+	encodedJSON, err := json.Marshal(authConfig)
+	if err != nil {
+		panic(err)
+	}
+	return base64.URLEncoding.EncodeToString(encodedJSON)
+}
+
+// readCredentials 读取 JSON 或 YAML 格式的认证文件，格式为 { "仓库地址": { "username": "", "password": "", "token": "" } }
+func readCredentials(file string) map[string]registryCredential {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+	var raw map[string]registryCredential
+	err = yaml.Unmarshal(data, &raw)
+	if err != nil {
+		panic(err)
+	}
+	credentials := make(map[string]registryCredential, len(raw))
+	for host, credential := range raw {
+		credentials[normalizeHost(host)] = credential
+	}
+	return credentials
+}
+
+// destCredential 返回登录 Docker Hub 使用的认证信息：--username/--password 覆盖认证文件中的同名字段。
+// 查询 Docker Hub（tag 展开、digest、目标镜像是否存在等）同样使用该账号，因此同时写回 credentials，
+// 只有用户名时仍匿名访问
+func destCredential(credentials map[string]registryCredential, username, password string) registryCredential {
+	dest := credentials[dockerHubHost]
+	if username != "" {
+		dest.Username = username
+	}
+	if password != "" {
+		dest.Password = password
+	}
+	if dest.Username != "" && (dest.Password != "" || dest.Token != "") {
+		credentials[dockerHubHost] = dest
+	}
+	return dest
+}
+
+// normalizeHost 统一 Docker Hub 的各种地址写法
+func normalizeHost(host string) string {
+	switch host {
+	case "index.docker.io", "registry-1.docker.io", "https://index.docker.io/v1/":
+		return dockerHubHost
+	}
+	return host
+}
+
+// sourceAuth 返回拉取或查询 image 时使用的 X-Registry-Auth，认证文件中没有该仓库时为空
+func sourceAuth(credentials map[string]registryCredential, image string) string {
+	host := imageHost(image)
+	if credential, ok := credentials[host]; ok {
+		return encodeAuth(credential.authConfig(host))
+	}
+	return ""
+}
+
+// imageHost 返回镜像所在的仓库地址
+func imageHost(image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return ""
+	}
+	return reference.Domain(named)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestReadCredentials(t *testing.T) {
+	want := map[string]registryCredential{
+		"docker.io":      {Username: "hub-user", Password: "hub-pass"},
+		"ghcr.io":        {Username: "gh-user", Password: "gh-pass"},
+		"quay.io":        {Token: "quay-token"},
+		"registry.local": {Username: "local", Password: "local-pass"},
+	}
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{"yaml", "credentials.yaml", `
+index.docker.io:
+  username: hub-user
+  password: hub-pass
+ghcr.io:
+  username: gh-user
+  password: gh-pass
+quay.io:
+  token: quay-token
+registry.local:
+  username: local
+  password: local-pass
+`},
+		{"json", "credentials.json", `{
+  "https://index.docker.io/v1/": {"username": "hub-user", "password": "hub-pass"},
+  "ghcr.io": {"username": "gh-user", "password": "gh-pass"},
+  "quay.io": {"token": "quay-token"},
+  "registry.local": {"username": "local", "password": "local-pass"}
+}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(file, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if got := readCredentials(file); !reflect.DeepEqual(got, want) {
+				t.Errorf("readCredentials() = %#v, want %#v", got, want)
+			}
+		})
+	}
+
+	mustPanic(t, func() { readCredentials(filepath.Join(t.TempDir(), "missing.yaml")) })
+	invalid := filepath.Join(t.TempDir(), "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("- not\n- a map\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mustPanic(t, func() { readCredentials(invalid) })
+}
+
+func TestNormalizeHost(t *testing.T) {
+	for host, want := range map[string]string{
+		"docker.io":                   "docker.io",
+		"index.docker.io":             "docker.io",
+		"registry-1.docker.io":        "docker.io",
+		"https://index.docker.io/v1/": "docker.io",
+		"ghcr.io":                     "ghcr.io",
+		"registry.local:5000":         "registry.local:5000",
+	} {
+		if got := normalizeHost(host); got != want {
+			t.Errorf("normalizeHost(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestDestCredential(t *testing.T) {
+	tests := []struct {
+		name               string
+		file               *registryCredential
+		username, password string
+		want               registryCredential
+		// stored 为 true 时 credentials 中的 Docker Hub 账号应为 want
+		stored bool
+	}{
+		{"file only", &registryCredential{Username: "file-user", Password: "file-pass"}, "", "", registryCredential{Username: "file-user", Password: "file-pass"}, true},
+		{"flags override file", &registryCredential{Username: "file-user", Password: "file-pass"}, "flag-user", "flag-pass", registryCredential{Username: "flag-user", Password: "flag-pass"}, true},
+		{"username flag keeps file password", &registryCredential{Username: "file-user", Password: "file-pass"}, "flag-user", "", registryCredential{Username: "flag-user", Password: "file-pass"}, true},
+		{"password flag keeps file username", &registryCredential{Username: "file-user", Password: "file-pass"}, "", "flag-pass", registryCredential{Username: "file-user", Password: "flag-pass"}, true},
+		{"username flag with file token", &registryCredential{Token: "file-token"}, "flag-user", "", registryCredential{Username: "flag-user", Token: "file-token"}, true},
+		{"flags without file", nil, "flag-user", "flag-pass", registryCredential{Username: "flag-user", Password: "flag-pass"}, true},
+		{"username only stays anonymous", nil, "flag-user", "", registryCredential{Username: "flag-user"}, false},
+		{"nothing", nil, "", "", registryCredential{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credentials := map[string]registryCredential{"ghcr.io": {Username: "gh-user", Password: "gh-pass"}}
+			if tt.file != nil {
+				credentials[dockerHubHost] = *tt.file
+			}
+			got := destCredential(credentials, tt.username, tt.password)
+			if got != tt.want {
+				t.Errorf("destCredential() = %#v, want %#v", got, tt.want)
+			}
+			stored, ok := credentials[dockerHubHost]
+			if tt.stored && (!ok || stored != tt.want) {
+				t.Errorf("credentials[docker.io] = %#v, want %#v", stored, tt.want)
+			}
+			if !tt.stored && ok {
+				t.Errorf("credentials[docker.io] = %#v, want no Docker Hub entry", stored)
+			}
+			if credentials["ghcr.io"].Username != "gh-user" {
+				t.Error("destCredential changed another registry's credential")
+			}
+		})
+	}
+}
+
+func TestRegistryCredentialString(t *testing.T) {
+	tests := []struct {
+		credential registryCredential
+		want       string
+	}{
+		{registryCredential{Username: "user", Password: "p4ss-string"}, "user:******"},
+		{registryCredential{Token: "t0ken-string"}, ":******"},
+		{registryCredential{Username: "user"}, "user"},
+	}
+	for _, tt := range tests {
+		if got := tt.credential.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+		// 通过 %v 打印认证信息或包含它的 map 时同样隐藏
+		for _, printed := range []string{
+			fmt.Sprintf("%v", tt.credential),
+			fmt.Sprintf("%s", tt.credential),
+			fmt.Sprint(map[string]registryCredential{"docker.io": tt.credential}),
+		} {
+			if strings.Contains(printed, "p4ss-string") || strings.Contains(printed, "t0ken-string") {
+				t.Errorf("printed credential %q leaks the secret", printed)
+			}
+		}
+	}
+}
+
+func TestSourceAuth(t *testing.T) {
+	credentials := map[string]registryCredential{
+		"docker.io": {Username: "hub-user", Password: "hub-pass"},
+		"ghcr.io":   {Token: "gh-token"},
+	}
+	tests := []struct {
+		image string
+		want  *types.AuthConfig
+	}{
+		{"nginx:1.25", &types.AuthConfig{Username: "hub-user", Password: "hub-pass", ServerAddress: "docker.io"}},
+		{"docker.io/library/redis:7", &types.AuthConfig{Username: "hub-user", Password: "hub-pass", ServerAddress: "docker.io"}},
+		{"ghcr.io/org/app:v1", &types.AuthConfig{RegistryToken: "gh-token", ServerAddress: "ghcr.io"}},
+		{"quay.io/org/app:v1", nil},
+		{"Invalid Name", nil},
+	}
+	for _, tt := range tests {
+		got := sourceAuth(credentials, tt.image)
+		if tt.want == nil {
+			if got != "" {
+				t.Errorf("sourceAuth(%q) = %q, want empty", tt.image, got)
+			}
+			continue
+		}
+		data, err := base64.URLEncoding.DecodeString(got)
+		if err != nil {
+			t.Fatalf("sourceAuth(%q) is not base64: %v", tt.image, err)
+		}
+		var auth types.AuthConfig
+		if err := json.Unmarshal(data, &auth); err != nil {
+			t.Fatal(err)
+		}
+		if auth != *tt.want {
+			t.Errorf("sourceAuth(%q) = %#v, want %#v", tt.image, auth, *tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+
+	"github.com/moby/term"
+)
+
+// 状态文字的 ANSI 颜色
+const (
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+// stdoutTerminal 启动时标准输出是否为终端，--log-file 会将标准输出替换为管道
+var stdoutTerminal = term.IsTerminal(os.Stdout.Fd())
+
+// useColor 是否为状态文字着色，默认仅在标准输出为终端时着色
+var useColor = stdoutTerminal
+
+// statusText 为转换状态文字着色
+func statusText(status string) string {
+	if !useColor {
+		return status
+	}
+	switch status {
+	case "转换成功":
+		return colorGreen + status + colorReset
+	case "转换失败", "转换中断":
+		return colorRed + status + colorReset
+	case "跳过转换":
+		return colorYellow + status + colorReset
+	}
+	return status
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStatusText(t *testing.T) {
+	statuses := map[string]string{
+		"转换成功": colorGreen,
+		"转换失败": colorRed,
+		"转换中断": colorRed,
+		"跳过转换": colorYellow,
+		"开始转换": "",
+	}
+	old := useColor
+	defer func() { useColor = old }()
+
+	useColor = false
+	for status := range statuses {
+		if got := statusText(status); got != status || strings.Contains(got, "\033") {
+			t.Errorf("statusText(%q) without color = %q, want the plain text", status, got)
+		}
+	}
+
+	useColor = true
+	for status, color := range statuses {
+		want := status
+		if color != "" {
+			want = color + status + colorReset
+		}
+		if got := statusText(status); got != want {
+			t.Errorf("statusText(%q) with color = %q, want %q", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+)
+
+// maxAutoConcurrency --image-concurrency=auto 时同时转换的最大镜像数，
+// 过多的并发拉取和上传容易触发仓库限流
+const maxAutoConcurrency = 16
+
+// autoConcurrency 按 CPU 数估算同时转换的镜像数：拉取和上传主要等待网络，
+// 每个 CPU 转换 2 个，最少 2 个，最多 maxAutoConcurrency 个
+func autoConcurrency(cpus int) int {
+	n := cpus * 2
+	if n < 2 {
+		n = 2
+	}
+	if n > maxAutoConcurrency {
+		n = maxAutoConcurrency
+	}
+	return n
+}
+
+// parseConcurrency 解析 --image-concurrency，auto 时按 CPU 数自动选择并打印选择的值
+func parseConcurrency(value string) int {
+	if value == "auto" {
+		n := autoConcurrency(runtime.NumCPU())
+		fmt.Println("自动选择同时转换的镜像数：", n, "（CPU 数", runtime.NumCPU(), "）")
+		return n
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		panic("--image-concurrency must be a non-negative number or auto: " + value)
+	}
+	return n
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestAutoConcurrency(t *testing.T) {
+	tests := []struct{ cpus, want int }{
+		{0, 2},
+		{1, 2},
+		{2, 4},
+		{4, 8},
+		{8, 16},
+		{64, maxAutoConcurrency},
+	}
+	for _, tt := range tests {
+		if got := autoConcurrency(tt.cpus); got != tt.want {
+			t.Errorf("autoConcurrency(%d) = %d, want %d", tt.cpus, got, tt.want)
+		}
+	}
+	for cpus := 1; cpus <= 256; cpus++ {
+		if n := autoConcurrency(cpus); n < 2 || n > maxAutoConcurrency || n > 2*cpus {
+			t.Fatalf("autoConcurrency(%d) = %d, out of bounds", cpus, n)
+		}
+	}
+}
+
+func TestParseConcurrency(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"0", 0},
+		{"5", 5},
+	}
+	for _, tt := range tests {
+		if got := parseConcurrency(tt.value); got != tt.want {
+			t.Errorf("parseConcurrency(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+	if n := parseConcurrency("auto"); n < 2 || n > maxAutoConcurrency {
+		t.Errorf("parseConcurrency(auto) = %d, want between 2 and %d", n, maxAutoConcurrency)
+	}
+	for _, value := range []string{"-1", "many", ""} {
+		mustPanic(t, func() { parseConcurrency(value) })
+	}
+}
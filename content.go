@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// mirrorContent 原始镜像内容，格式为 { "hub-mirror": [], "custom-registry": "" }
+type mirrorContent struct {
+	Content []contentEntry `json:"hub-mirror"`
+	// CustomRegistry 自定义镜像仓库
+	CustomRegistry string `json:"custom-registry"`
+}
+
+// contentEntry hub-mirror 中的一项，可以是镜像字符串，也可以是
+// { "image": "", "expected-digest": "", "retries": 3, "timeout": "10m" } 对象
+type contentEntry struct {
+	Image string `json:"image"`
+	// ExpectedDigest 拉取后校验源镜像的 digest
+	ExpectedDigest string `json:"expected-digest,omitempty"`
+	// Retries 覆盖该镜像的 --retries
+	Retries *int `json:"retries,omitempty"`
+	// Timeout 该镜像转换（拉取、上传及重试）的总超时时间，如 10m
+	Timeout string `json:"timeout,omitempty"`
+}
+
+func (e *contentEntry) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &e.Image)
+	}
+	type plain contentEntry
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+func (e contentEntry) MarshalJSON() ([]byte, error) {
+	if e.ExpectedDigest == "" && e.Retries == nil && e.Timeout == "" {
+		return json.Marshal(e.Image)
+	}
+	type plain contentEntry
+	return json.Marshal(plain(e))
+}
+
+// readContentFile 读取原始镜像文件，file 也可以是 http(s):// 地址，headers 为请求 URL 时
+// 附加的请求头（如 Authorization: Bearer xxx）。format 为空时按扩展名判断：
+// .txt 为每行一个镜像（忽略空行和 # 注释），其它为与 --content 相同的 JSON
+func readContentFile(file, format string, headers []string) mirrorContent {
+	if format == "" {
+		format = "json"
+		ext := filepath.Ext(file)
+		if u, err := url.Parse(file); err == nil && isURL(file) {
+			ext = path.Ext(u.Path)
+		}
+		if ext == ".txt" {
+			format = "txt"
+		}
+	}
+	r := openContent(file, headers)
+	defer r.Close()
+	var c mirrorContent
+	switch format {
+	case "txt":
+		for _, line := range scanLines(r) {
+			c.Content = append(c.Content, contentEntry{Image: line})
+		}
+	case "json":
+		err := json.NewDecoder(r).Decode(&c)
+		if err != nil {
+			panic(err)
+		}
+	default:
+		panic("unknown content format: " + format)
+	}
+	return c
+}
+
+// entryOverrides 返回原始镜像中一项的重试次数和总超时时间，未设置 retries 时为 defaultRetries
+func entryOverrides(entry contentEntry, defaultRetries int) (int, time.Duration) {
+	retries := defaultRetries
+	if entry.Retries != nil {
+		retries = *entry.Retries
+	}
+	var timeout time.Duration
+	if entry.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(entry.Timeout)
+		if err != nil {
+			panic(fmt.Sprintf("invalid timeout for %s: %v", entry.Image, err))
+		}
+	}
+	return retries, timeout
+}
+
+// planEntry 将转换计划还原为原始镜像中的一项，retries 与 defaultRetries 相同时不写出
+func planEntry(plan mirrorOutput, defaultRetries int) contentEntry {
+	entry := contentEntry{
+		Image:          plan.Image,
+		ExpectedDigest: plan.ExpectedDigest,
+	}
+	if plan.Retries != defaultRetries {
+		entry.Retries = &plan.Retries
+	}
+	if plan.Timeout > 0 {
+		entry.Timeout = plan.Timeout.String()
+	}
+	return entry
+}
+
+// mergeContent 合并多个来源的原始镜像内容，相同的镜像只保留第一次出现的一项，
+// 各来源设置了不同的 custom-registry 时报错
+func mergeContent(contents ...mirrorContent) mirrorContent {
+	var merged mirrorContent
+	seen := make(map[string]bool)
+	for _, c := range contents {
+		if c.CustomRegistry != "" {
+			if merged.CustomRegistry != "" && normalizeRegistry(merged.CustomRegistry) != normalizeRegistry(c.CustomRegistry) {
+				panic(fmt.Sprintf("conflicting custom-registry: %s and %s", merged.CustomRegistry, c.CustomRegistry))
+			}
+			merged.CustomRegistry = c.CustomRegistry
+		}
+		for _, entry := range c.Content {
+			if seen[entry.Image] {
+				continue
+			}
+			seen[entry.Image] = true
+			merged.Content = append(merged.Content, entry)
+		}
+	}
+	return merged
+}
+
+// isURL 判断是否为 http(s):// 地址
+func isURL(file string) bool {
+	return strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://")
+}
+
+// openContent 打开本地文件，或通过 HTTP 获取 URL 的内容
+func openContent(file string, headers []string) io.ReadCloser {
+	if !isURL(file) {
+		f, err := os.Open(file)
+		if err != nil {
+			panic(err)
+		}
+		return f
+	}
+	req, err := http.NewRequest(http.MethodGet, file, nil)
+	if err != nil {
+		panic(err)
+	}
+	for _, header := range headers {
+		name, value := parseHeader(header)
+		req.Header.Add(name, value)
+	}
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		panic(fmt.Sprintf("fetch %s: %v", file, err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		panic(fmt.Sprintf("fetch %s: %s", file, resp.Status))
+	}
+	return resp.Body
+}
+
+// parseHeader 解析 Name: value 格式的请求头
+func parseHeader(header string) (name, value string) {
+	index := strings.Index(header, ":")
+	if index == -1 {
+		panic("invalid header, expected Name: value: " + header)
+	}
+	return strings.TrimSpace(header[:index]), strings.TrimSpace(header[index+1:])
+}
+
+// writeContent 将原始镜像内容写入文件，可再通过 --retry-file 读取
+func writeContent(file string, c mirrorContent) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	err = os.WriteFile(file, data, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
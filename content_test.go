@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// images 返回镜像内容中的镜像名
+func images(c mirrorContent) []string {
+	result := make([]string, 0, len(c.Content))
+	for _, entry := range c.Content {
+		result = append(result, entry.Image)
+	}
+	return result
+}
+
+// mustPanic 执行 f 并返回 panic 的内容，没有 panic 时测试失败
+func mustPanic(t *testing.T, f func()) (message string) {
+	t.Helper()
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatal("expected a panic")
+		}
+		message, _ = r.(string)
+		if err, ok := r.(error); ok {
+			message = err.Error()
+		}
+	}()
+	f()
+	return ""
+}
+
+func TestReadContentFileURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/images.json", "/list":
+			w.Write([]byte(`{"hub-mirror":["nginx:1.25",{"image":"redis:7","retries":1}],"custom-registry":"registry.example.com"}`))
+		case "/images.txt":
+			w.Write([]byte("# images\nnginx:1.25\n\nredis:7\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	headers := []string{"Authorization: Bearer secret"}
+
+	tests := []struct {
+		name, path, format string
+		want               []string
+		customRegistry     string
+	}{
+		{"json", "/images.json", "", []string{"nginx:1.25", "redis:7"}, "registry.example.com"},
+		{"txt by extension", "/images.txt", "", []string{"nginx:1.25", "redis:7"}, ""},
+		{"txt extension ignores query", "/images.txt?ref=main", "", []string{"nginx:1.25", "redis:7"}, ""},
+		{"default json", "/list", "", []string{"nginx:1.25", "redis:7"}, "registry.example.com"},
+		{"explicit format", "/images.txt", "txt", []string{"nginx:1.25", "redis:7"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := readContentFile(server.URL+tt.path, tt.format, headers)
+			if got := images(c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("images = %q, want %q", got, tt.want)
+			}
+			if c.CustomRegistry != tt.customRegistry {
+				t.Errorf("custom-registry = %q, want %q", c.CustomRegistry, tt.customRegistry)
+			}
+		})
+	}
+
+	failures := []struct {
+		name, path, format string
+		headers            []string
+		want               string
+	}{
+		{"not found", "/missing.json", "", headers, "404 Not Found"},
+		{"missing header", "/images.json", "", nil, "401 Unauthorized"},
+		{"json as txt", "/images.txt", "json", headers, "invalid character"},
+		{"unknown format", "/images.json", "yaml", headers, "unknown content format: yaml"},
+		{"invalid header", "/images.json", "", []string{"Authorization"}, "invalid header"},
+	}
+	for _, tt := range failures {
+		t.Run(tt.name, func(t *testing.T) {
+			message := mustPanic(t, func() { readContentFile(server.URL+tt.path, tt.format, tt.headers) })
+			if !strings.Contains(message, tt.want) {
+				t.Errorf("panic = %q, want it to contain %q", message, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadContentFileLocal(t *testing.T) {
+	dir := t.TempDir()
+	txt := filepath.Join(dir, "images.txt")
+	if err := os.WriteFile(txt, []byte("nginx:1.25\nredis:7\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := images(readContentFile(txt, "", nil)), []string{"nginx:1.25", "redis:7"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("images = %q, want %q", got, want)
+	}
+
+	// writeContent 写出的文件可以原样读回
+	file := filepath.Join(dir, "retry.json")
+	retries := 2
+	c := mirrorContent{Content: []contentEntry{{Image: "nginx:1.25"}, {Image: "redis:7", Retries: &retries, Timeout: "5m0s"}}, CustomRegistry: "registry.example.com"}
+	writeContent(file, c)
+	if got := readContentFile(file, "", nil); !reflect.DeepEqual(got, c) {
+		t.Errorf("readContentFile(writeContent(c)) = %+v, want %+v", got, c)
+	}
+}
+
+func TestMergeContent(t *testing.T) {
+	retries := 5
+	var inline mirrorContent
+	if err := json.Unmarshal([]byte(`{
+		"hub-mirror": ["nginx:1.25", {"image": "redis:7", "retries": 5}],
+		"custom-registry": "registry.example.com"
+	}`), &inline); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "images.json")
+	if err := os.WriteFile(file, []byte(`{
+		"hub-mirror": ["redis:7", "alpine:3.19", "nginx:1.25"],
+		"custom-registry": "https://registry.example.com/"
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	merged := mergeContent(inline, readContentFile(file, "", nil))
+	if got, want := images(merged), []string{"nginx:1.25", "redis:7", "alpine:3.19"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged images = %v, want %v", got, want)
+	}
+	// 重复的镜像保留第一次出现的一项
+	if redis := merged.Content[1]; redis.Retries == nil || *redis.Retries != retries {
+		t.Errorf("merged redis:7 = %+v, want the inline entry with retries %d", redis, retries)
+	}
+	if normalizeRegistry(merged.CustomRegistry) != "registry.example.com" {
+		t.Errorf("merged custom-registry = %q, want registry.example.com", merged.CustomRegistry)
+	}
+
+	tests := []struct {
+		name   string
+		a, b   string
+		want   string
+		panics bool
+	}{
+		{"same", "registry.example.com", "registry.example.com", "registry.example.com", false},
+		{"scheme", "https://registry.example.com", "registry.example.com", "registry.example.com", false},
+		{"trailing slash", "registry.example.com/", "registry.example.com", "registry.example.com", false},
+		{"only first", "registry.example.com", "", "registry.example.com", false},
+		{"only second", "", "registry.example.com", "registry.example.com", false},
+		{"conflict", "registry.example.com", "other.example.com", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := mirrorContent{Content: []contentEntry{{Image: "nginx:1.25"}}, CustomRegistry: tt.a}
+			b := mirrorContent{Content: []contentEntry{{Image: "redis:7"}}, CustomRegistry: tt.b}
+			if tt.panics {
+				message := mustPanic(t, func() { mergeContent(a, b) })
+				if !strings.Contains(message, "conflicting custom-registry") {
+					t.Errorf("panic = %q, want a custom-registry conflict", message)
+				}
+				return
+			}
+			got := mergeContent(a, b)
+			if normalizeRegistry(got.CustomRegistry) != tt.want {
+				t.Errorf("custom-registry = %q, want %q", got.CustomRegistry, tt.want)
+			}
+			if !reflect.DeepEqual(images(got), []string{"nginx:1.25", "redis:7"}) {
+				t.Errorf("images = %v, want both sources", images(got))
+			}
+		})
+	}
+}
+
+// TestFailuresRoundTrip 失败的镜像写入 failures.json 后通过 --retry-file 读回，单独设置的 retries、timeout 和 expected-digest 保持不变
+func TestFailuresRoundTrip(t *testing.T) {
+	const defaultRetries = 1
+	plans := []mirrorOutput{
+		{Image: "nginx:1.25", Retries: defaultRetries},
+		{Image: "redis:7", Retries: 4, Timeout: 10 * time.Minute},
+		{Image: "alpine:3.19", Retries: 0, ExpectedDigest: testDigest},
+		{Image: "ghcr.io/org/app:v1", Retries: defaultRetries, Timeout: 90 * time.Second},
+	}
+	var c mirrorContent
+	for _, plan := range plans {
+		c.Content = append(c.Content, planEntry(plan, defaultRetries))
+	}
+	if c.Content[0].Retries != nil || c.Content[3].Retries != nil {
+		t.Errorf("planEntry wrote the default retries: %+v", c.Content)
+	}
+	file := filepath.Join(t.TempDir(), "failures.json")
+	writeContent(file, c)
+
+	read := readContentFile(file, "json", nil)
+	if len(read.Content) != len(plans) {
+		t.Fatalf("read %d entries, want %d", len(read.Content), len(plans))
+	}
+	for i, entry := range read.Content {
+		plan := plans[i]
+		retries, timeout := entryOverrides(entry, defaultRetries)
+		if entry.Image != plan.Image || entry.ExpectedDigest != plan.ExpectedDigest {
+			t.Errorf("entry %d = %+v, want image %s and expected digest %q", i, entry, plan.Image, plan.ExpectedDigest)
+		}
+		if retries != plan.Retries || timeout != plan.Timeout {
+			t.Errorf("%s overrides = %d, %s; want %d, %s", plan.Image, retries, timeout, plan.Retries, plan.Timeout)
+		}
+	}
+
+	// 重试时修改了 --retries，未单独设置的镜像使用新的默认值
+	if retries, _ := entryOverrides(read.Content[0], 3); retries != 3 {
+		t.Errorf("retries = %d with a new default, want 3", retries)
+	}
+	if retries, _ := entryOverrides(read.Content[2], 3); retries != 0 {
+		t.Errorf("retries = %d for an explicit 0, want 0", retries)
+	}
+
+	message := mustPanic(t, func() { entryOverrides(contentEntry{Image: "nginx:1.25", Timeout: "soon"}, 0) })
+	if !strings.Contains(message, "invalid timeout for nginx:1.25") {
+		t.Errorf("panic = %q, want an invalid timeout error", message)
+	}
+}
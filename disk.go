@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-units"
+)
+
+// isNoSpace 判断是否为磁盘空间不足的错误
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || strings.Contains(strings.ToLower(err.Error()), "no space left on device")
+}
+
+// reportNoSpace 打印 Docker 数据目录的剩余空间和处理建议
+func reportNoSpace(cli *client.Client) {
+	fmt.Println("磁盘空间不足，停止拉取其它镜像")
+	info, err := cli.Info(context.Background())
+	if err == nil {
+		if free, err := freeSpace(info.DockerRootDir); err == nil {
+			fmt.Println("Docker 数据目录", info.DockerRootDir, "剩余空间", units.BytesSize(float64(free)))
+		} else {
+			fmt.Println("Docker 数据目录", info.DockerRootDir)
+		}
+	}
+	fmt.Println("建议使用 --gc-before 清理悬空镜像或 --gc-all 清理所有未使用的镜像和数据卷后重试，或减少每次转换的镜像个数")
+}
+
+// pruneImages 清理无用镜像并打印释放的空间，all 为 false 时只清理悬空镜像，
+// 为 true 时清理所有未被容器使用的镜像和数据卷
+func pruneImages(ctx context.Context, cli *client.Client, all bool) {
+	images, err := cli.ImagesPrune(ctx, filters.NewArgs(filters.Arg("dangling", fmt.Sprint(!all))))
+	if err != nil {
+		panic(err)
+	}
+	reclaimed := images.SpaceReclaimed
+	fmt.Println("已清理", len(images.ImagesDeleted), "个镜像")
+	if all {
+		volumes, err := cli.VolumesPrune(ctx, filters.NewArgs())
+		if err != nil {
+			panic(err)
+		}
+		reclaimed += volumes.SpaceReclaimed
+		fmt.Println("已清理", len(volumes.VolumesDeleted), "个数据卷")
+	}
+	fmt.Println("共释放空间", units.BytesSize(float64(reclaimed)))
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+// freeSpace 其它系统下 Statfs_t 的字段不一致，不查询剩余空间
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.New("not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+func TestIsNoSpace(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"errno", syscall.ENOSPC, true},
+		{"wrapped errno", fmt.Errorf("pull nginx:1.25: %w", &os.PathError{Op: "write", Path: "/var/lib/docker/tmp/layer", Err: syscall.ENOSPC}), true},
+		// Docker 守护进程在 JSON 消息流中以文本返回错误
+		{"daemon message", &jsonmessage.JSONError{Message: "write /var/lib/docker/tmp/GetImageBlob123: no space left on device"}, true},
+		{"daemon message upper case", errors.New("failed to register layer: Error processing tar file(exit status 1): write /usr/lib/x: No space left on device"), true},
+		{"quota", syscall.EDQUOT, false},
+		{"other", errors.New("unexpected EOF"), false},
+	}
+	for _, tt := range tests {
+		if got := isNoSpace(tt.err); got != tt.want {
+			t.Errorf("%s: isNoSpace(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestReportNoSpace(t *testing.T) {
+	dir := t.TempDir()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/info") {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"DockerRootDir": dir})
+	}))
+	defer server.Close()
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+strings.TrimPrefix(server.URL, "http://")), client.WithVersion("1.41"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t, func() { reportNoSpace(cli) })
+	wants := []string{dir, "--gc-before", "--gc-all"}
+	if _, err := freeSpace(dir); err == nil {
+		wants = append(wants, "剩余空间")
+	}
+	for _, want := range wants {
+		if !strings.Contains(out, want) {
+			t.Errorf("reportNoSpace output does not mention %q:\n%s", want, out)
+		}
+	}
+}
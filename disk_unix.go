@@ -0,0 +1,16 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package main
+
+import "syscall"
+
+// freeSpace 返回 dir 所在磁盘的剩余空间，只在 Docker 守护进程运行在本机时准确
+func freeSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(dir, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// drainOnSignal 收到 signals 中的信号时取消 ctx，停止开始新的转换；再等待 gracePeriod 后
+// 取消 drainCtx，中断进行中的上传。stop 取消两者并结束等待信号的 goroutine
+func drainOnSignal(signals <-chan os.Signal, gracePeriod time.Duration) (ctx, drainCtx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	drainCtx, cancelDrain := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Println("收到", sig, "信号，停止拉取新镜像，等待进行中的上传完成，最长", gracePeriod)
+			cancel()
+			time.AfterFunc(gracePeriod, cancelDrain)
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return ctx, drainCtx, func() {
+		once.Do(func() {
+			close(done)
+			cancel()
+			cancelDrain()
+		})
+	}
+}
+
+// runPlans 每隔 ramp 开始一个镜像的转换，slots 不为 nil 时限制同时转换的数量，全部结束后返回。
+// ctx 取消后不再开始新的转换，尚未开始的镜像记录为中断
+func runPlans(ctx context.Context, plans []mirrorOutput, ramp time.Duration, slots chan struct{}, inFlight *int64,
+	mirror func(*mirrorOutput) error, recordFailure func(mirrorOutput, string)) {
+	interrupted := func(plan mirrorOutput) {
+		fmt.Println(statusText("转换中断"), plan.Pull, "=>", plan.Target)
+		recordFailure(plan, "interrupted")
+	}
+	var wg sync.WaitGroup
+	for i, plan := range plans {
+		// 错开各镜像开始的时间，避免同时发起大量请求触发限流
+		if ramp > 0 && i > 0 {
+			select {
+			case <-time.After(ramp):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			interrupted(plan)
+			continue
+		}
+		wg.Add(1)
+		go func(plan mirrorOutput) {
+			defer wg.Done()
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+					defer func() { <-slots }()
+				case <-ctx.Done():
+					// 等待期间收到中断信号，未开始转换
+					interrupted(plan)
+					return
+				}
+				// 槽位和中断信号同时就绪时 select 随机选择，取得槽位后再确认一次
+				if ctx.Err() != nil {
+					interrupted(plan)
+					return
+				}
+			}
+			atomic.AddInt64(inFlight, 1)
+			defer atomic.AddInt64(inFlight, -1)
+			runMirror(plan, mirror, recordFailure)
+		}(plan)
+	}
+	wg.Wait()
+}
+
+// runMirror 转换单个镜像，出错时记录为失败，不影响其它镜像；意外的 panic 同样记录为失败并打印堆栈
+func runMirror(plan mirrorOutput, mirror func(*mirrorOutput) error, recordFailure func(mirrorOutput, string)) {
+	defer func() {
+		if r := recover(); r != nil {
+			reason := redact(fmt.Sprint(r))
+			fmt.Fprintf(os.Stderr, "%s %s => %s: %s\n%s", statusText("转换失败"), plan.Pull, plan.Target, reason, debug.Stack())
+			recordFailure(plan, reason)
+		}
+	}()
+	if err := mirror(&plan); err != nil {
+		reason := redact(err.Error())
+		fmt.Fprintf(os.Stderr, "%s %s => %s: %s\n", statusText("转换失败"), plan.Pull, plan.Target, reason)
+		recordFailure(plan, reason)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// waitGoroutines 等待 goroutine 数回落到 n 以下，返回最终的数量
+func waitGoroutines(n int) int {
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return runtime.NumGoroutine()
+}
+
+func TestDrainOnSignal(t *testing.T) {
+	signals := make(chan os.Signal, 1)
+	ctx, drainCtx, stop := drainOnSignal(signals, 100*time.Millisecond)
+	defer stop()
+	if ctx.Err() != nil || drainCtx.Err() != nil {
+		t.Fatal("contexts cancelled before any signal")
+	}
+
+	signals <- syscall.SIGTERM
+	sent := time.Now()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("ctx not cancelled after SIGTERM")
+	}
+	if drainCtx.Err() != nil {
+		t.Fatal("drainCtx cancelled before the grace period")
+	}
+	select {
+	case <-drainCtx.Done():
+		if elapsed := time.Since(sent); elapsed < 100*time.Millisecond {
+			t.Errorf("drainCtx cancelled after %s, want at least the grace period", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("drainCtx not cancelled after the grace period")
+	}
+}
+
+func TestDrainOnSignalStop(t *testing.T) {
+	before := runtime.NumGoroutine()
+	signals := make(chan os.Signal, 1)
+	ctx, drainCtx, stop := drainOnSignal(signals, time.Hour)
+	stop()
+	stop()
+	if ctx.Err() == nil || drainCtx.Err() == nil {
+		t.Error("stop did not cancel both contexts")
+	}
+	if n := waitGoroutines(before); n > before {
+		t.Errorf("goroutines = %d after stop, want %d", n, before)
+	}
+}
+
+// drainRecorder 记录 runPlans 中每个镜像的结果
+type drainRecorder struct {
+	mu       sync.Mutex
+	started  []string
+	done     []string
+	failures map[string]string
+}
+
+func (r *drainRecorder) recordFailure(plan mirrorOutput, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures[plan.Pull] = reason
+}
+
+func (r *drainRecorder) sorted(list []string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := append([]string(nil), list...)
+	sort.Strings(out)
+	return out
+}
+
+// TestRunPlansDrain 模拟 SIGTERM：进行中的上传在宽限期内完成，之后的镜像不再开始并记录为中断
+func TestRunPlansDrain(t *testing.T) {
+	tests := []struct {
+		name  string
+		ramp  time.Duration
+		slots int
+	}{
+		{"ramp", 50 * time.Millisecond, 0},
+		{"slots", 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			signals := make(chan os.Signal, 1)
+			ctx, drainCtx, stop := drainOnSignal(signals, time.Second)
+			defer stop()
+
+			plans := []mirrorOutput{{Pull: "a:1"}, {Pull: "b:1"}, {Pull: "c:1"}, {Pull: "d:1"}}
+			rec := &drainRecorder{failures: make(map[string]string)}
+			pushing := make(chan struct{})
+			release := make(chan struct{})
+			var first sync.Once
+			mirror := func(plan *mirrorOutput) error {
+				rec.mu.Lock()
+				rec.started = append(rec.started, plan.Pull)
+				rec.mu.Unlock()
+				// 第一个开始的镜像在收到信号时仍在上传
+				blocking := false
+				first.Do(func() { blocking = true })
+				if blocking {
+					close(pushing)
+					// 上传不受 ctx 取消影响，只在宽限期结束时中断
+					select {
+					case <-release:
+					case <-drainCtx.Done():
+						return drainCtx.Err()
+					}
+				}
+				rec.mu.Lock()
+				rec.done = append(rec.done, plan.Pull)
+				rec.mu.Unlock()
+				return nil
+			}
+			var slots chan struct{}
+			if tt.slots > 0 {
+				slots = make(chan struct{}, tt.slots)
+			}
+			go func() {
+				<-pushing
+				signals <- syscall.SIGTERM
+				<-ctx.Done()
+				// 收到信号后仍未开始的镜像有机会被错误地启动
+				time.Sleep(2 * tt.ramp)
+				close(release)
+			}()
+
+			var inFlight int64
+			finished := make(chan struct{})
+			go func() {
+				runPlans(ctx, plans, tt.ramp, slots, &inFlight, mirror, rec.recordFailure)
+				close(finished)
+			}()
+			select {
+			case <-finished:
+			case <-time.After(5 * time.Second):
+				t.Fatal("runPlans did not return after the in-flight push finished")
+			}
+
+			started := rec.sorted(rec.started)
+			if len(started) != 1 {
+				t.Fatalf("started = %v, want only the in-flight image", started)
+			}
+			if got := rec.sorted(rec.done); !reflect.DeepEqual(got, started) {
+				t.Errorf("done = %v, want the in-flight push %v to finish", got, started)
+			}
+			want := make(map[string]string)
+			for _, plan := range plans {
+				if plan.Pull != started[0] {
+					want[plan.Pull] = "interrupted"
+				}
+			}
+			if !reflect.DeepEqual(rec.failures, want) {
+				t.Errorf("failures = %v, want %v", rec.failures, want)
+			}
+			if inFlight != 0 {
+				t.Errorf("inFlight = %d after runPlans, want 0", inFlight)
+			}
+			stop()
+			if n := waitGoroutines(before); n > before {
+				t.Errorf("goroutines = %d after runPlans, want %d", n, before)
+			}
+		})
+	}
+}
+
+// TestRunPlansGracePeriod 宽限期结束时中断仍在进行的上传
+func TestRunPlansGracePeriod(t *testing.T) {
+	signals := make(chan os.Signal, 1)
+	ctx, drainCtx, stop := drainOnSignal(signals, 50*time.Millisecond)
+	defer stop()
+	rec := &drainRecorder{failures: make(map[string]string)}
+	mirror := func(plan *mirrorOutput) error {
+		signals <- syscall.SIGINT
+		<-drainCtx.Done()
+		return context.Canceled
+	}
+	var inFlight int64
+	runPlans(ctx, []mirrorOutput{{Pull: "a:1"}}, 0, nil, &inFlight, mirror, rec.recordFailure)
+	if rec.failures["a:1"] != context.Canceled.Error() {
+		t.Errorf("failures = %v, want a:1 recorded after the grace period", rec.failures)
+	}
+}
+
+func TestRunMirror(t *testing.T) {
+	rec := &drainRecorder{failures: make(map[string]string)}
+	var succeeded []string
+	var mu sync.Mutex
+	mirror := func(plan *mirrorOutput) error {
+		switch plan.Pull {
+		case "panic:1":
+			var m map[string]int
+			m["x"]++
+		case "error:1":
+			return errors.New("pull failed")
+		}
+		mu.Lock()
+		succeeded = append(succeeded, plan.Pull)
+		mu.Unlock()
+		return nil
+	}
+	plans := []mirrorOutput{{Pull: "a:1"}, {Pull: "panic:1"}, {Pull: "b:1"}, {Pull: "error:1"}, {Pull: "c:1"}}
+	var inFlight int64
+	runPlans(context.Background(), plans, 0, nil, &inFlight, mirror, rec.recordFailure)
+
+	sort.Strings(succeeded)
+	if want := []string{"a:1", "b:1", "c:1"}; !reflect.DeepEqual(succeeded, want) {
+		t.Errorf("succeeded = %v, want %v", succeeded, want)
+	}
+	if len(rec.failures) != 2 {
+		t.Fatalf("failures = %v, want the panicking and failing images", rec.failures)
+	}
+	if reason := rec.failures["panic:1"]; !strings.Contains(reason, "nil map") {
+		t.Errorf("panic reason = %q, want the recovered panic", reason)
+	}
+	if reason := rec.failures["error:1"]; reason != "pull failed" {
+		t.Errorf("error reason = %q, want pull failed", reason)
+	}
+}
+
+func TestRunMirrorRedacts(t *testing.T) {
+	withSecrets(t, "s3cr3t-runmirror")
+	rec := &drainRecorder{failures: make(map[string]string)}
+	runMirror(mirrorOutput{Pull: "a:1"}, func(*mirrorOutput) error {
+		panic("login with s3cr3t-runmirror failed")
+	}, rec.recordFailure)
+	runMirror(mirrorOutput{Pull: "b:1"}, func(*mirrorOutput) error {
+		return errors.New("token s3cr3t-runmirror rejected")
+	}, rec.recordFailure)
+	for image, reason := range rec.failures {
+		if strings.Contains(reason, "s3cr3t-runmirror") {
+			t.Errorf("%s failure reason %q leaks the secret", image, reason)
+		}
+	}
+	if len(rec.failures) != 2 {
+		t.Errorf("failures = %v, want both images", rec.failures)
+	}
+}
+
+// BenchmarkRunPlans 在不同并发数下通过 runPlans 转换 200 个镜像，每个镜像向假仓库查询源镜像 digest 并确认目标镜像是否存在
+func BenchmarkRunPlans(b *testing.B) {
+	f := newFakeRegistry(b, true)
+	rc := f.client()
+	var plans []mirrorOutput
+	for i := 0; i < 200; i++ {
+		repo := fmt.Sprintf("library/app%d", i)
+		f.setManifest(repo, "1.0", map[string]interface{}{"schemaVersion": 2, "mediaType": "application/vnd.docker.distribution.manifest.v2+json"})
+		source := f.host + "/" + repo + ":1.0"
+		plans = append(plans, mirrorOutput{Image: source, Pull: source, Source: source, Target: f.host + "/mirror/app" + strconv.Itoa(i) + ":1.0"})
+	}
+	mirror := func(plan *mirrorOutput) error {
+		digest, err := rc.digest(context.Background(), plan.Pull)
+		if err != nil {
+			return err
+		}
+		plan.PushedDigest = digest
+		_, err = rc.exists(context.Background(), plan.Target)
+		return err
+	}
+	for _, concurrency := range []int{1, 4, 16, 64} {
+		b.Run(strconv.Itoa(concurrency), func(b *testing.B) {
+			var failures int64
+			recordFailure := func(mirrorOutput, string) { atomic.AddInt64(&failures, 1) }
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var inFlight int64
+				runPlans(context.Background(), plans, 0, make(chan struct{}, concurrency), &inFlight, mirror, recordFailure)
+			}
+			if failures > 0 {
+				b.Fatalf("%d images failed", failures)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/docker/distribution/reference"
+)
+
+// 漂移检查结果
+const (
+	driftUnchanged = "unchanged"
+	driftChanged   = "drifted"
+	driftNew       = "new"
+	driftError     = "error"
+)
+
+// driftEntry 单个源镜像 tag 的漂移检查结果
+type driftEntry struct {
+	Source   string `json:"source"`
+	Baseline string `json:"baseline,omitempty"`
+	Current  string `json:"current,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// checkDrift 将每个源镜像 tag 当前的 digest 与基准映射文件中记录的 digest 比较，
+// 已固定 digest 的源镜像不会漂移，不做检查
+func checkDrift(ctx context.Context, rc *registryClient, plans []mirrorOutput, base []mappingEntry) []driftEntry {
+	digests := make(map[string]string, len(base))
+	for _, entry := range base {
+		digests[entry.Source] = entry.Digest
+	}
+	// 按原始镜像的顺序保存结果
+	results := make([]driftEntry, len(plans))
+	var wg sync.WaitGroup
+	for i, plan := range plans {
+		if _, d := splitDigest(plan.Pull); d != "" {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, plan mirrorOutput) {
+			defer wg.Done()
+			entry := driftEntry{Source: plan.Source, Baseline: digests[plan.Source]}
+			current, err := rc.digest(ctx, plan.Pull)
+			switch {
+			case err != nil:
+				entry.Status, entry.Error = driftError, redact(err.Error())
+			case entry.Baseline == "":
+				entry.Status, entry.Current = driftNew, current
+			case entry.Baseline == current:
+				entry.Status, entry.Current = driftUnchanged, current
+			default:
+				entry.Status, entry.Current = driftChanged, current
+			}
+			results[i] = entry
+		}(i, plan)
+	}
+	wg.Wait()
+	entries := make([]driftEntry, 0, len(results))
+	for _, entry := range results {
+		if entry.Status != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// writeDrift 打印漂移的镜像并写入报告，返回是否存在漂移或检查失败
+func writeDrift(file string, entries []driftEntry) bool {
+	found := false
+	for _, entry := range entries {
+		switch entry.Status {
+		case driftChanged:
+			found = true
+			fmt.Println("[漂移]", entry.Source, entry.Baseline, "=>", entry.Current)
+		case driftError:
+			found = true
+			fmt.Println("[失败]", entry.Source+":", entry.Error)
+		case driftNew:
+			fmt.Println("[新增]", entry.Source, entry.Current)
+		}
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	err = os.WriteFile(file, data, 0644)
+	if err != nil {
+		panic(err)
+	}
+	return found
+}
+
+// digest 返回镜像当前的 manifest digest，多平台镜像为清单列表的 digest，与 RepoDigests 一致。
+// 先用 HEAD 读取 Docker-Content-Digest，Docker Hub 不将其计入拉取次数限制，仓库未返回该请求头时才 GET manifest 计算
+func (c *registryClient) digest(ctx context.Context, image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	named = reference.TagNameOnly(named)
+	tag := named.(reference.Tagged).Tag()
+	host, repo := reference.Domain(named), reference.Path(named)
+	url := c.endpoint(host) + "/v2/" + repo + "/manifests/" + tag
+	header := http.Header{"Accept": {strings.Join(manifestAccept, ", ")}}
+	resp, err := c.do(ctx, http.MethodHead, host, url, header)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("head manifest %s:%s: %s", repo, tag, resp.Status)
+	}
+	if d := resp.Header.Get("Docker-Content-Digest"); d != "" {
+		return d, nil
+	}
+	resp, err = c.do(ctx, http.MethodGet, host, url, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get manifest %s:%s: %s", repo, tag, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
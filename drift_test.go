@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRegistryDigest(t *testing.T) {
+	tests := []struct {
+		name           string
+		noDigestHeader bool
+		want           []string
+	}{
+		{"head only", false, []string{"HEAD /v2/library/app/manifests/1.0"}},
+		{"get fallback", true, []string{"HEAD /v2/library/app/manifests/1.0", "GET /v2/library/app/manifests/1.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeRegistry(t, false)
+			f.noDigestHeader = tt.noDigestHeader
+			want := f.setManifest("library/app", "1.0", testManifest("sha256:a1"))
+			got, err := f.client().digest(context.Background(), f.host+"/library/app:1.0")
+			if err != nil || got != want {
+				t.Fatalf("digest = %q, %v, want %q", got, err, want)
+			}
+			if requests := f.requestLog(); !reflect.DeepEqual(requests, tt.want) {
+				t.Errorf("requests = %q, want %q", requests, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	f := newFakeRegistry(t, false)
+	same := f.setManifest("library/same", "1.0", testManifest("sha256:s1"))
+	f.setManifest("library/drifted", "1.0", testManifest("sha256:d1"))
+	drifted := f.setManifest("library/drifted", "1.0", testManifest("sha256:d2"))
+	added := f.setManifest("library/new", "1.0", testManifest("sha256:n1"))
+	image := func(repo string) string { return f.host + "/library/" + repo + ":1.0" }
+	plan := func(source string) mirrorOutput { return mirrorOutput{Pull: source, Source: source} }
+	pinned := f.host + "/library/pinned@" + testDigest
+
+	plans := []mirrorOutput{plan(image("same")), plan(image("drifted")), plan(pinned), plan(image("new")), plan(image("missing"))}
+	base := []mappingEntry{
+		{Source: image("same"), Digest: same},
+		{Source: image("drifted"), Digest: "sha256:old"},
+		{Source: image("missing"), Digest: "sha256:gone"},
+	}
+	entries := checkDrift(context.Background(), f.client(), plans, base)
+	want := []driftEntry{
+		{Source: image("same"), Baseline: same, Current: same, Status: driftUnchanged},
+		{Source: image("drifted"), Baseline: "sha256:old", Current: drifted, Status: driftChanged},
+		{Source: image("new"), Current: added, Status: driftNew},
+	}
+	if len(entries) != 4 {
+		t.Fatalf("checkDrift returned %d entries, want 4 (pinned digests are not checked): %+v", len(entries), entries)
+	}
+	if !reflect.DeepEqual(entries[:3], want) {
+		t.Errorf("checkDrift = %+v, want %+v", entries[:3], want)
+	}
+	if entries[3].Source != image("missing") || entries[3].Status != driftError || entries[3].Error == "" {
+		t.Errorf("missing image entry = %+v, want an error", entries[3])
+	}
+
+	// 存在漂移或检查失败时 writeDrift 返回 true，报告与检查结果一致
+	tests := []struct {
+		name    string
+		entries []driftEntry
+		want    bool
+	}{
+		{"all", entries, true},
+		{"unchanged and new", []driftEntry{entries[0], entries[2]}, false},
+		{"drifted", entries[1:2], true},
+		{"error", entries[3:], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "drift.json")
+			if got := writeDrift(file, tt.entries); got != tt.want {
+				t.Errorf("writeDrift = %v, want %v", got, tt.want)
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var report []driftEntry
+			if err := json.Unmarshal(data, &report); err != nil || !reflect.DeepEqual(report, tt.entries) {
+				t.Errorf("report = %s, want %+v", data, tt.entries)
+			}
+		})
+	}
+}
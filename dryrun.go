@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/docker/distribution/reference"
+)
+
+// --dry-run 的模式
+const (
+	// dryRunPlan 只输出转换计划，不访问任何网络
+	dryRunPlan = "plan"
+	// dryRunCheck 只读查询源镜像和目标镜像，不拉取、不上传
+	dryRunCheck = "check"
+)
+
+// checkDryRunMode 校验 --dry-run 的模式。plan 模式不访问网络，需要网络的输入直接报错而不是静默忽略
+func checkDryRunMode(mode string) {
+	switch mode {
+	case "", dryRunCheck:
+	case dryRunPlan:
+		if *vaultPath != "" {
+			panic("--vault-path requires network access, use --dry-run=check")
+		}
+		if isURL(*contentFile) {
+			panic("--contentFile from URL requires network access, use --dry-run=check")
+		}
+		if len(*allTags) > 0 {
+			panic("--all-tags requires registry access, use --dry-run=check")
+		}
+		// helm template 可能从 chart 仓库或 OCI 仓库下载 chart 及其依赖
+		if *contentFromHelm != "" {
+			panic("--content-from-helm may download charts, use --dry-run=check")
+		}
+	default:
+		panic("unknown --dry-run mode: " + mode)
+	}
+}
+
+// planCheck 单个镜像的只读检查结果
+type planCheck struct {
+	plan mirrorOutput
+	// digest 源镜像当前的 digest
+	digest string
+	// sourceErr 查询源镜像失败的原因
+	sourceErr error
+	// targetExists 目标镜像是否已存在，targetErr 不为空时无意义
+	targetExists bool
+	targetErr    error
+}
+
+// checkPlans 并发查询每个源镜像的 digest 和目标镜像是否已存在，只发送 GET/HEAD 请求，
+// 按计划的顺序打印结果，返回所有源镜像是否都可访问
+func checkPlans(ctx context.Context, w io.Writer, rc *registryClient, plans []mirrorOutput) bool {
+	checks := make([]planCheck, len(plans))
+	var wg sync.WaitGroup
+	for i, plan := range plans {
+		wg.Add(1)
+		go func(i int, plan mirrorOutput) {
+			defer wg.Done()
+			check := planCheck{plan: plan}
+			if _, d := splitDigest(plan.Pull); d != "" {
+				check.digest = d
+				if found, err := rc.exists(ctx, plan.Pull); err != nil {
+					check.sourceErr = err
+				} else if !found {
+					check.sourceErr = fmt.Errorf("manifest %s not found", plan.Pull)
+				}
+			} else {
+				check.digest, check.sourceErr = rc.digest(ctx, plan.Pull)
+			}
+			check.targetExists, check.targetErr = rc.exists(ctx, plan.Target)
+			checks[i] = check
+		}(i, plan)
+	}
+	wg.Wait()
+
+	ok := true
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tTARGET\tDIGEST\tTARGET STATUS")
+	for _, check := range checks {
+		digest := check.digest
+		if check.sourceErr != nil {
+			ok = false
+			digest = "查询失败：" + redact(check.sourceErr.Error())
+		}
+		status := "不存在"
+		switch {
+		case check.targetErr != nil:
+			status = "查询失败：" + redact(check.targetErr.Error())
+		case check.targetExists:
+			status = "已存在"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", check.plan.Pull, check.plan.Target, digest, status)
+	}
+	tw.Flush()
+	return ok
+}
+
+// exists 通过 HEAD 请求判断镜像的 manifest 是否存在
+func (c *registryClient) exists(ctx context.Context, image string) (bool, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return false, err
+	}
+	named = reference.TagNameOnly(named)
+	var ref string
+	if digested, ok := named.(reference.Digested); ok {
+		ref = digested.Digest().String()
+	} else {
+		ref = named.(reference.Tagged).Tag()
+	}
+	host, repo := reference.Domain(named), reference.Path(named)
+	header := http.Header{"Accept": {strings.Join(manifestAccept, ", ")}}
+	resp, err := c.do(ctx, http.MethodHead, host, c.endpoint(host)+"/v2/"+repo+"/manifests/"+ref, header)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("head manifest %s:%s: %s", repo, ref, resp.Status)
+}
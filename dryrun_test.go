@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCheckDryRunMode(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  string
+		setup func(t *testing.T)
+		// want 为空时不应报错
+		want string
+	}{
+		{"off", "", nil, ""},
+		{"plan", dryRunPlan, nil, ""},
+		{"check with network inputs", dryRunCheck, func(t *testing.T) {
+			setFlag(t, vaultPath, "secret/hub-mirror")
+			setFlag(t, contentFromHelm, "oci://registry.local/charts/app")
+		}, ""},
+		{"plan with local content file", dryRunPlan, func(t *testing.T) { setFlag(t, contentFile, "images.txt") }, ""},
+		{"plan with vault", dryRunPlan, func(t *testing.T) { setFlag(t, vaultPath, "secret/hub-mirror") }, "--vault-path"},
+		{"plan with content URL", dryRunPlan, func(t *testing.T) { setFlag(t, contentFile, "https://example.com/images.json") }, "--contentFile from URL"},
+		{"plan with all tags", dryRunPlan, func(t *testing.T) {
+			old := *allTags
+			*allTags = []string{"nginx"}
+			t.Cleanup(func() { *allTags = old })
+		}, "--all-tags"},
+		{"plan with helm", dryRunPlan, func(t *testing.T) { setFlag(t, contentFromHelm, "./charts/app") }, "--content-from-helm"},
+		{"unknown mode", "apply", nil, "unknown --dry-run mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+			if tt.want == "" {
+				checkDryRunMode(tt.mode)
+				return
+			}
+			if message := mustPanic(t, func() { checkDryRunMode(tt.mode) }); !strings.Contains(message, tt.want) {
+				t.Errorf("panic = %q, want it to mention %q", message, tt.want)
+			}
+		})
+	}
+}
+
+// TestCheckPlans --dry-run=check 只读查询源镜像和目标镜像，不发送任何写请求
+func TestCheckPlans(t *testing.T) {
+	f := &fakeRegistry{manifests: make(map[string][]byte), blobs: make(map[string][]byte), auth: true, tokens: make(map[string]bool), expiresIn: 300}
+	var mu sync.Mutex
+	var methods []string
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		f.serveHTTP(w, r)
+	}))
+	defer f.server.Close()
+	f.host = strings.TrimPrefix(f.server.URL, "http://")
+
+	appDigest := f.setManifest("library/app", "1.0", testManifest("sha256:a1"))
+	pinnedDigest := f.setManifest("library/pinned", "2.0", testManifest("sha256:b2"))
+	f.setManifest("user/app", "1.0", testManifest("sha256:a1"))
+	plans := []mirrorOutput{
+		{Pull: f.host + "/library/app:1.0", Target: f.host + "/user/app:1.0"},
+		{Pull: f.host + "/library/pinned@" + pinnedDigest, Target: f.host + "/user/pinned:2.0"},
+	}
+
+	var out bytes.Buffer
+	if !checkPlans(context.Background(), &out, f.client(), plans) {
+		t.Fatalf("checkPlans failed:\n%s", out.String())
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("checkPlans output has %d lines, want a header and 2 rows:\n%s", len(lines), out.String())
+	}
+	if !strings.Contains(lines[1], appDigest) || !strings.HasSuffix(lines[1], "已存在") {
+		t.Errorf("tag source row = %q, want digest %s and an existing target", lines[1], appDigest)
+	}
+	if !strings.Contains(lines[2], pinnedDigest) || !strings.HasSuffix(lines[2], "不存在") {
+		t.Errorf("digest source row = %q, want digest %s and a missing target", lines[2], pinnedDigest)
+	}
+
+	// 源镜像不存在时返回 false，并继续输出其它镜像
+	out.Reset()
+	missing := append(plans, mirrorOutput{Pull: f.host + "/library/missing:1.0", Target: f.host + "/user/missing:1.0"})
+	if checkPlans(context.Background(), &out, f.client(), missing) {
+		t.Errorf("checkPlans succeeded with a missing source:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "查询失败") || strings.Count(out.String(), "\n") != 4 {
+		t.Errorf("checkPlans output = %q, want every row and the failed lookup", out.String())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(methods) == 0 {
+		t.Fatal("checkPlans sent no requests")
+	}
+	for _, request := range methods {
+		if !strings.HasPrefix(request, http.MethodGet+" ") && !strings.HasPrefix(request, http.MethodHead+" ") {
+			t.Errorf("checkPlans sent %s, want only GET and HEAD", request)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/pflag"
+)
+
+// envPrefix 环境变量前缀
+const envPrefix = "HUBMIRROR_"
+
+// applyEnv 未在命令行中指定的参数使用环境变量 HUBMIRROR_<NAME> 的值，如 maxContent 对应 HUBMIRROR_MAX_CONTENT，
+// 可重复指定的 StringArray 参数（如 --header）每行一个值
+func applyEnv(flags *pflag.FlagSet) {
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		values := []string{value}
+		if f.Value.Type() == "stringArray" {
+			values = strings.Split(value, "\n")
+		}
+		for _, v := range values {
+			err := flags.Set(f.Name, v)
+			if err != nil {
+				panic(fmt.Sprintf("invalid value %q for %s: %v", v, envName(f.Name), err))
+			}
+		}
+	})
+}
+
+// envName 将参数名转换为环境变量名
+func envName(flag string) string {
+	var b strings.Builder
+	b.WriteString(envPrefix)
+	prev := rune(0)
+	for _, r := range flag {
+		switch {
+		case r == '-':
+			b.WriteRune('_')
+		case unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)):
+			b.WriteRune('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+		prev = r
+	}
+	return b.String()
+}
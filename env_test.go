@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := []struct{ flag, want string }{
+		{"username", "HUBMIRROR_USERNAME"},
+		{"maxContent", "HUBMIRROR_MAX_CONTENT"},
+		{"contentFile", "HUBMIRROR_CONTENT_FILE"},
+		{"dest-repo-template", "HUBMIRROR_DEST_REPO_TEMPLATE"},
+		{"push-timeout", "HUBMIRROR_PUSH_TIMEOUT"},
+		{"v2Auth", "HUBMIRROR_V2_AUTH"},
+		{"outputPath", "HUBMIRROR_OUTPUT_PATH"},
+	}
+	for _, tt := range tests {
+		if got := envName(tt.flag); got != tt.want {
+			t.Errorf("envName(%q) = %q, want %q", tt.flag, got, tt.want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	username := flags.String("username", "", "")
+	maxContent := flags.Int("maxContent", 10, "")
+	dryRun := flags.Bool("dry-run", false, "")
+	headers := flags.StringArray("header", nil, "")
+	namespace := flags.String("namespace", "default", "")
+	if err := flags.Parse([]string{"--username=cli"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HUBMIRROR_USERNAME", "env")
+	t.Setenv("HUBMIRROR_MAX_CONTENT", "20")
+	t.Setenv("HUBMIRROR_DRY_RUN", "true")
+	t.Setenv("HUBMIRROR_HEADER", "X-A: 1\nregistry.example.com=X-B: 2")
+	applyEnv(flags)
+
+	if *username != "cli" {
+		t.Errorf("username = %q, command line value should win", *username)
+	}
+	if *maxContent != 20 {
+		t.Errorf("maxContent = %d, want 20", *maxContent)
+	}
+	if !*dryRun {
+		t.Errorf("dry-run = false, want true")
+	}
+	if want := []string{"X-A: 1", "registry.example.com=X-B: 2"}; !reflect.DeepEqual(*headers, want) {
+		t.Errorf("header = %q, want %q", *headers, want)
+	}
+	if *namespace != "default" {
+		t.Errorf("namespace = %q, unset env should keep the default", *namespace)
+	}
+}
+
+func TestApplyEnvInvalid(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.Int("maxContent", 10, "")
+	t.Setenv("HUBMIRROR_MAX_CONTENT", "many")
+	defer func() {
+		if recover() == nil {
+			t.Error("applyEnv did not panic on an invalid value")
+		}
+	}()
+	applyEnv(flags)
+}
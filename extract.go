@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// imageSet 按加入顺序去重的镜像列表
+type imageSet struct {
+	images []string
+	seen   map[string]bool
+}
+
+func (s *imageSet) add(image string) {
+	if image == "" || s.seen[image] {
+		return
+	}
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	s.seen[image] = true
+	s.images = append(s.images, image)
+}
+
+// extractImages 使用 walk 从 YAML 文档（可包含多个文档）中提取镜像加入 set
+func extractImages(r io.Reader, set *imageSet, walk func(*yaml.Node, func(string))) error {
+	decoder := yaml.NewDecoder(r)
+	for {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		walk(&doc, set.add)
+	}
+}
+
+// walkImages 递归查找 values 风格的 image 字段，支持字符串和 registry/repository/tag/digest 拆分写法
+func walkImages(node *yaml.Node, add func(string)) {
+	if node.Kind != yaml.MappingNode {
+		for _, child := range node.Content {
+			walkImages(child, add)
+		}
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Value != "image" {
+			walkImages(value, add)
+			continue
+		}
+		switch value.Kind {
+		case yaml.ScalarNode:
+			add(value.Value)
+		case yaml.MappingNode:
+			add(joinImage(value))
+		}
+	}
+}
+
+// joinImage 将 { registry, repository, tag, digest } 拼接为镜像名
+func joinImage(node *yaml.Node) string {
+	fields := make(map[string]string)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		fields[node.Content[i].Value] = node.Content[i+1].Value
+	}
+	image := fields["repository"]
+	if image == "" {
+		return ""
+	}
+	if registry := fields["registry"]; registry != "" {
+		image = registry + "/" + image
+	}
+	if tag := fields["tag"]; tag != "" {
+		image += ":" + tag
+	}
+	if digest := fields["digest"]; digest != "" {
+		image += "@" + digest
+	}
+	return image
+}
+
+// helmImages 使用 helm template 渲染 chart 并提取其中的容器镜像。渲染结果是 Kubernetes 清单，
+// 只查找容器中的 image，避免把注解、ConfigMap 等处的 image 字段误当作镜像
+func helmImages(chart, values string) []string {
+	args := []string{"template", "hub-mirror", chart}
+	if values != "" {
+		args = append(args, "--values", values)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("helm", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		panic(fmt.Sprintf("helm template %s: %v: %s", chart, err, stderr.String()))
+	}
+	var set imageSet
+	err = extractImages(&stdout, &set, walkContainers)
+	if err != nil {
+		panic(err)
+	}
+	return set.images
+}
+
+// walkContainers 递归查找 containers、initContainers、ephemeralContainers 中的 image
+func walkContainers(node *yaml.Node, add func(string)) {
+	if node.Kind != yaml.MappingNode {
+		for _, child := range node.Content {
+			walkContainers(child, add)
+		}
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		switch key.Value {
+		case "containers", "initContainers", "ephemeralContainers":
+			if value.Kind != yaml.SequenceNode {
+				continue
+			}
+			for _, container := range value.Content {
+				for j := 0; j+1 < len(container.Content); j += 2 {
+					if container.Content[j].Value == "image" {
+						add(container.Content[j+1].Value)
+					}
+				}
+			}
+		default:
+			walkContainers(value, add)
+		}
+	}
+}
+
+// k8sImages 从 Kubernetes 清单文件或目录（递归查找 .yaml/.yml）中提取所有容器镜像
+func k8sImages(paths []string) []string {
+	var set imageSet
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			ext := filepath.Ext(file)
+			if d.IsDir() || (file != root && ext != ".yaml" && ext != ".yml") {
+				return nil
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			err = extractImages(f, &set, walkContainers)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			return nil
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	return set.images
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const testManifests = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  annotations:
+    image: not-a-container
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: init
+          image: busybox:1.36
+      containers:
+        - name: web
+          image: nginx:1.25
+        - name: sidecar
+          image: gcr.io/google-containers/pause:3.9
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+spec:
+  template:
+    spec:
+      containers:
+        - name: agent
+          image: quay.io/prometheus/node-exporter:v1.7.0
+        - name: web
+          image: nginx:1.25
+      ephemeralContainers:
+        - name: debug
+          image: busybox:1.36
+---
+apiVersion: batch/v1
+kind: CronJob
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: job
+              image: alpine:3.19
+`
+
+func TestWalkContainers(t *testing.T) {
+	var set imageSet
+	err := extractImages(strings.NewReader(testManifests), &set, walkContainers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"busybox:1.36",
+		"nginx:1.25",
+		"gcr.io/google-containers/pause:3.9",
+		"quay.io/prometheus/node-exporter:v1.7.0",
+		"alpine:3.19",
+	}
+	if !reflect.DeepEqual(set.images, want) {
+		t.Errorf("walkContainers = %q, want %q", set.images, want)
+	}
+}
+
+func TestK8sImages(t *testing.T) {
+	dir := t.TempDir()
+	docs := strings.Split(testManifests, "---\n")
+	files := map[string]string{
+		"deploy.yaml":          docs[0],
+		"nested/daemonset.yml": docs[1],
+		"cronjob.json":         docs[2],
+		"README.md":            "image: ignored:1",
+	}
+	for name, text := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := k8sImages([]string{dir, filepath.Join(dir, "cronjob.json")})
+	want := []string{
+		"busybox:1.36",
+		"nginx:1.25",
+		"gcr.io/google-containers/pause:3.9",
+		"quay.io/prometheus/node-exporter:v1.7.0",
+		"alpine:3.19",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("k8sImages = %q, want %q", got, want)
+	}
+}
+
+func TestWalkImages(t *testing.T) {
+	values := `image: nginx:1.25
+redis:
+  image:
+    registry: docker.io
+    repository: bitnami/redis
+    tag: "7.2"
+sidecar:
+  image:
+    repository: busybox
+    digest: ` + testDigest + `
+empty:
+  image:
+    tag: "1.0"
+`
+	var set imageSet
+	err := extractImages(strings.NewReader(values), &set, walkImages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"nginx:1.25", "docker.io/bitnami/redis:7.2", "busybox@" + testDigest}
+	if !reflect.DeepEqual(set.images, want) {
+		t.Errorf("walkImages = %q, want %q", set.images, want)
+	}
+}
+
+func TestHelmImages(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake helm is a shell script")
+	}
+	// 用假的 helm 输出渲染结果，其中 ConfigMap 的 image 字段和注解不是容器镜像
+	dir := t.TempDir()
+	rendered := filepath.Join(dir, "rendered.yaml")
+	manifests := testManifests + `---
+apiVersion: v1
+kind: ConfigMap
+data:
+  image: not-a-container:1
+`
+	if err := os.WriteFile(rendered, []byte(manifests), 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "args") + "\ncat " + rendered + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "helm"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	got := helmImages("./chart", "values.yaml")
+	want := []string{
+		"busybox:1.36",
+		"nginx:1.25",
+		"gcr.io/google-containers/pause:3.9",
+		"quay.io/prometheus/node-exporter:v1.7.0",
+		"alpine:3.19",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("helmImages = %q, want %q", got, want)
+	}
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(args)); got != "template hub-mirror ./chart --values values.yaml" {
+		t.Errorf("helm args = %q, want template with --values", got)
+	}
+}
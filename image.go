@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/docker/distribution/reference"
+	"gopkg.in/yaml.v3"
+)
+
+// parseSource 解析源镜像，返回拉取时使用的引用和脚本中还原的镜像名，
+// digestLength 为仅有 digest 时生成 tag 使用的 hash 长度，0 表示完整 hash
+func parseSource(source string, digestLength int) (pull, restore string) {
+	name, digest := splitDigest(source)
+	if digest == "" {
+		return source, source
+	}
+	repo, tag := splitTag(name)
+	if tag == "" {
+		// 去除 @sha256，将后面的 hash 作为 tag
+		hash := strings.TrimPrefix(digest, "sha256:")
+		if digestLength > 0 && digestLength < len(hash) {
+			hash = hash[:digestLength]
+		}
+		return source, repo + ":" + hash
+	}
+	// 同时包含 tag 和 digest 时，按 digest 拉取，使用 tag 命名
+	return repo + "@" + digest, name
+}
+
+// isLatest 判断源镜像是否指向 latest：未指定 tag 或 tag 为 latest，且未固定 digest
+func isLatest(source string) bool {
+	name, digest := splitDigest(source)
+	if digest != "" {
+		return false
+	}
+	_, tag := splitTag(name)
+	return tag == "" || tag == "latest"
+}
+
+// checkLatest 对使用 latest 的镜像输出警告，strict 为 true（--no-latest）时直接报错
+func checkLatest(w io.Writer, latest []string, strict bool) {
+	if len(latest) == 0 {
+		return
+	}
+	if strict {
+		panic(fmt.Sprintf("images use the latest tag: %v", latest))
+	}
+	fmt.Fprintf(w, "警告：以下镜像使用 latest tag，建议指定版本：%v\n", latest)
+}
+
+// digestTagLength 返回由 digest 生成 tag 时使用的 hash 长度，
+// 不同 digest 截断后相同时自动加长，直到批次中不再冲突
+func digestTagLength(sources []string, length int) int {
+	if length <= 0 {
+		return 0
+	}
+	hashes := make([]string, 0)
+	for _, source := range sources {
+		name, digest := splitDigest(source)
+		if _, tag := splitTag(name); digest != "" && tag == "" {
+			hashes = append(hashes, strings.TrimPrefix(digest, "sha256:"))
+		}
+	}
+	for ; ; length++ {
+		seen := make(map[string]string, len(hashes))
+		collision := false
+		for _, hash := range hashes {
+			if len(hash) <= length {
+				continue
+			}
+			prefix := hash[:length]
+			if other, ok := seen[prefix]; ok && other != hash {
+				collision = true
+				break
+			}
+			seen[prefix] = hash
+		}
+		if !collision {
+			return length
+		}
+	}
+}
+
+// splitDigest 拆分镜像名中的 digest，没有 digest 时返回空字符串
+func splitDigest(source string) (name, digest string) {
+	index := strings.Index(source, "@")
+	if index == -1 {
+		return source, ""
+	}
+	return source[:index], source[index+1:]
+}
+
+// splitTag 拆分镜像名中的 tag，没有 tag 时返回空字符串
+func splitTag(name string) (repo, tag string) {
+	index := strings.LastIndex(name, ":")
+	if index == -1 || strings.Contains(name[index:], "/") {
+		return name, ""
+	}
+	return name[:index], name[index+1:]
+}
+
+// qualifiedName 返回包含仓库地址的完整镜像名，如 nginx:1.25 => docker.io/library/nginx:1.25
+func qualifiedName(restore string) string {
+	named, err := reference.ParseNormalizedNamed(restore)
+	if err != nil {
+		return restore
+	}
+	return named.String()
+}
+
+// targetRef 目标镜像名模板的数据
+type targetRef struct {
+	// Namespace 目标命名空间，默认为用户名
+	Namespace string
+	// Repo 源镜像的仓库名，如 gcr.io/google-containers/pause
+	Repo string
+	// Host 源镜像的仓库地址，如 docker.io
+	Host string
+	// Path 去除仓库地址后的仓库路径，如 library/nginx
+	Path string
+	Tag  string
+}
+
+// targetNamer 按 --dest-repo-template 和 --dest-tag-template 计算目标镜像名
+type targetNamer struct {
+	repo *template.Template
+	tag  *template.Template
+	// tagReplaces 依次应用于目标 tag 的正则替换
+	tagReplaces []tagReplace
+	// hostRepo、hostTag 按源镜像仓库地址覆盖的模板
+	hostRepo map[string]*template.Template
+	hostTag  map[string]*template.Template
+}
+
+// hostTemplate --host-templates 文件中单个仓库地址的模板，为空时使用全局模板
+type hostTemplate struct {
+	Repo string `yaml:"repo"`
+	Tag  string `yaml:"tag"`
+}
+
+// readHostTemplates 读取 JSON 或 YAML 格式的模板文件，格式为 { "仓库地址": { "repo": "", "tag": "" } }
+func readHostTemplates(file string) map[string]hostTemplate {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+	var raw map[string]hostTemplate
+	err = yaml.Unmarshal(data, &raw)
+	if err != nil {
+		panic(err)
+	}
+	hosts := make(map[string]hostTemplate, len(raw))
+	for host, t := range raw {
+		hosts[normalizeHost(host)] = t
+	}
+	return hosts
+}
+
+// tagReplace --tag-regex-replace 中的一项
+type tagReplace struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// newTargetNamer 解析目标镜像名模板，tagReplaces 的格式为 pattern=>replacement，
+// hosts 中匹配源镜像仓库地址的模板优先于全局模板
+func newTargetNamer(repoText, tagText string, tagReplaces []string, hosts map[string]hostTemplate) *targetNamer {
+	n := &targetNamer{
+		repo:     template.Must(template.New("dest-repo").Funcs(templateFuncs).Parse(repoText)),
+		tag:      template.Must(template.New("dest-tag").Funcs(templateFuncs).Parse(tagText)),
+		hostRepo: make(map[string]*template.Template),
+		hostTag:  make(map[string]*template.Template),
+	}
+	for host, t := range hosts {
+		if t.Repo != "" {
+			n.hostRepo[host] = template.Must(template.New("dest-repo " + host).Funcs(templateFuncs).Parse(t.Repo))
+		}
+		if t.Tag != "" {
+			n.hostTag[host] = template.Must(template.New("dest-tag " + host).Funcs(templateFuncs).Parse(t.Tag))
+		}
+	}
+	for _, replace := range tagReplaces {
+		index := strings.Index(replace, "=>")
+		if index == -1 {
+			panic("invalid --tag-regex-replace, expected pattern=>replacement: " + replace)
+		}
+		pattern, err := regexp.Compile(replace[:index])
+		if err != nil {
+			panic(fmt.Sprintf("invalid --tag-regex-replace %q: %v", replace, err))
+		}
+		n.tagReplaces = append(n.tagReplaces, tagReplace{pattern: pattern, replacement: replace[index+2:]})
+	}
+	return n
+}
+
+// name 计算转换后的目标镜像名，tag 模板渲染为空时不带 tag
+func (n *targetNamer) name(namespace, restore string) string {
+	ref := targetRef{Namespace: namespace}
+	ref.Repo, ref.Tag = splitTag(restore)
+	if named, err := reference.ParseNormalizedNamed(ref.Repo); err == nil {
+		ref.Host, ref.Path = reference.Domain(named), reference.Path(named)
+	}
+	repoTmpl, tagTmpl := n.repo, n.tag
+	if t, ok := n.hostRepo[ref.Host]; ok {
+		repoTmpl = t
+	}
+	if t, ok := n.hostTag[ref.Host]; ok {
+		tagTmpl = t
+	}
+	var repo, tag bytes.Buffer
+	if err := repoTmpl.Execute(&repo, ref); err != nil {
+		panic(err)
+	}
+	if err := tagTmpl.Execute(&tag, ref); err != nil {
+		panic(err)
+	}
+	t := tag.String()
+	for _, replace := range n.tagReplaces {
+		t = replace.pattern.ReplaceAllString(t, replace.replacement)
+	}
+	if t == "" {
+		return repo.String()
+	}
+	return repo.String() + ":" + t
+}
+
+// targetCollisions 返回映射到同一目标镜像的不同源镜像，key 为目标镜像，value 按出现顺序列出源镜像
+func targetCollisions(plans []mirrorOutput) map[string][]string {
+	owners := make(map[string]string)
+	collided := make(map[string][]string)
+	for _, plan := range plans {
+		owner, ok := owners[plan.Target]
+		if !ok {
+			owners[plan.Target] = plan.Source
+		} else if owner != plan.Source && !contains(collided[plan.Target], plan.Source) {
+			if len(collided[plan.Target]) == 0 {
+				collided[plan.Target] = []string{owner}
+			}
+			collided[plan.Target] = append(collided[plan.Target], plan.Source)
+		}
+	}
+	return collided
+}
+
+// disambiguate 在目标镜像的仓库名后添加源镜像的 hash 后缀，用于区分映射到同一目标的不同源镜像
+func disambiguate(target, source string) string {
+	repo, tag := splitTag(target)
+	sum := sha256.Sum256([]byte(source))
+	repo += "-" + hex.EncodeToString(sum[:4])
+	if tag == "" {
+		return repo
+	}
+	return repo + ":" + tag
+}
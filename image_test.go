@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		source        string
+		digestLength  int
+		pull, restore string
+	}{
+		{"nginx", 0, "nginx", "nginx"},
+		{"nginx:1.25", 0, "nginx:1.25", "nginx:1.25"},
+		{"localhost:5000/app:v1", 0, "localhost:5000/app:v1", "localhost:5000/app:v1"},
+		{"nginx@" + testDigest, 0, "nginx@" + testDigest, "nginx:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+		{"nginx@" + testDigest, 12, "nginx@" + testDigest, "nginx:0123456789ab"},
+		{"nginx@" + testDigest, 100, "nginx@" + testDigest, "nginx:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+		{"nginx:1.25@" + testDigest, 12, "nginx@" + testDigest, "nginx:1.25"},
+		{"localhost:5000/app@" + testDigest, 8, "localhost:5000/app@" + testDigest, "localhost:5000/app:01234567"},
+	}
+	for _, tt := range tests {
+		pull, restore := parseSource(tt.source, tt.digestLength)
+		if pull != tt.pull || restore != tt.restore {
+			t.Errorf("parseSource(%q, %d) = %q, %q, want %q, %q", tt.source, tt.digestLength, pull, restore, tt.pull, tt.restore)
+		}
+	}
+}
+
+func TestIsLatest(t *testing.T) {
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{"nginx", true},
+		{"nginx:latest", true},
+		{"nginx:1.25", false},
+		{"localhost:5000/app", true},
+		{"nginx@" + testDigest, false},
+		{"nginx:latest@" + testDigest, false},
+	}
+	for _, tt := range tests {
+		if got := isLatest(tt.source); got != tt.want {
+			t.Errorf("isLatest(%q) = %v, want %v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestDigestTagLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []string
+		length  int
+		want    int
+	}{
+		{"full hash", []string{"a@sha256:aaaa1111"}, 0, 0},
+		{"no collision", []string{"a@sha256:aaaa1111", "b@sha256:bbbb2222"}, 4, 4},
+		{"collision", []string{"a@sha256:aaaa1111", "b@sha256:aaaa2222"}, 4, 5},
+		{"same digest", []string{"a@sha256:aaaa1111", "b@sha256:aaaa1111"}, 4, 4},
+		{"longer collision", []string{"a@sha256:aaaaaa11", "b@sha256:aaaaaa22"}, 2, 7},
+		{"tagged ignored", []string{"a:v1@sha256:aaaa1111", "b@sha256:aaaa2222"}, 4, 4},
+		{"no digest", []string{"nginx:1.25", "redis"}, 12, 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := digestTagLength(tt.sources, tt.length); got != tt.want {
+				t.Errorf("digestTagLength(%q, %d) = %d, want %d", tt.sources, tt.length, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQualifiedName(t *testing.T) {
+	tests := []struct{ restore, want string }{
+		{"nginx:1.25", "docker.io/library/nginx:1.25"},
+		{"user/app", "docker.io/user/app"},
+		{"gcr.io/google-containers/pause:3.9", "gcr.io/google-containers/pause:3.9"},
+		{"Invalid Name", "Invalid Name"},
+	}
+	for _, tt := range tests {
+		if got := qualifiedName(tt.restore); got != tt.want {
+			t.Errorf("qualifiedName(%q) = %q, want %q", tt.restore, got, tt.want)
+		}
+	}
+}
+
+// defaultNamer 使用 --dest-repo-template 和 --dest-tag-template 默认值的 targetNamer
+func defaultNamer() *targetNamer {
+	return newTargetNamer(pflag.Lookup("dest-repo-template").DefValue, pflag.Lookup("dest-tag-template").DefValue, nil, nil)
+}
+
+func TestTargetCollisions(t *testing.T) {
+	namer := defaultNamer()
+	plan := func(source string) mirrorOutput {
+		return mirrorOutput{Source: source, Target: namer.name("user", source)}
+	}
+	tests := []struct {
+		name  string
+		plans []mirrorOutput
+		want  map[string][]string
+	}{
+		{"distinct", []mirrorOutput{plan("nginx:1.25"), plan("redis:7")}, map[string][]string{}},
+		{"same source twice", []mirrorOutput{plan("nginx:1.25"), plan("nginx:1.25")}, map[string][]string{}},
+		{
+			"default template flattens slashes",
+			[]mirrorOutput{plan("a/b.c:1"), plan("nginx:1.25"), plan("a.b/c:1"), plan("a/b.c:1")},
+			map[string][]string{"user/a.b.c:1": {"a/b.c:1", "a.b/c:1"}},
+		},
+		{
+			"three sources",
+			[]mirrorOutput{plan("x/y.z:1"), plan("x.y/z:1"), plan("x.y.z:1")},
+			map[string][]string{"user/x.y.z:1": {"x/y.z:1", "x.y/z:1", "x.y.z:1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := targetCollisions(tt.plans); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("targetCollisions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisambiguate(t *testing.T) {
+	a := disambiguate("user/a.b.c:1", "a/b.c:1")
+	b := disambiguate("user/a.b.c:1", "a.b/c:1")
+	if a == b {
+		t.Fatalf("disambiguate returned %q for both sources", a)
+	}
+	for _, got := range []string{a, b} {
+		repo, tag := splitTag(got)
+		if tag != "1" || !strings.HasPrefix(repo, "user/a.b.c-") || len(repo) != len("user/a.b.c-")+8 {
+			t.Errorf("disambiguate = %q, want user/a.b.c-<8 hex>:1", got)
+		}
+	}
+	if again := disambiguate("user/a.b.c:1", "a/b.c:1"); again != a {
+		t.Errorf("disambiguate is not stable: %q != %q", again, a)
+	}
+	if got := disambiguate("user/app", "app"); strings.Contains(got, ":") {
+		t.Errorf("disambiguate(untagged) = %q, want no tag", got)
+	}
+	if collided := targetCollisions([]mirrorOutput{{Source: "a/b.c:1", Target: a}, {Source: "a.b/c:1", Target: b}}); len(collided) != 0 {
+		t.Errorf("suffixed targets still collide: %v", collided)
+	}
+}
+
+func TestTargetNamerTemplates(t *testing.T) {
+	tests := []struct {
+		name, repo, tag string
+		restore, want   string
+	}{
+		{"default", "", "", "nginx:1.25", "user/nginx:1.25"},
+		{"default nested", "", "", "gcr.io/google-containers/pause:3.9", "user/gcr.io.google-containers.pause:3.9"},
+		{"default untagged", "", "", "nginx", "user/nginx"},
+		{"path only", "{{ .Namespace }}/{{ .Path | basename }}", "", "gcr.io/google-containers/pause:3.9", "user/pause:3.9"},
+		{"host prefix", `{{ .Namespace }}/{{ .Host }}-{{ replace "/" "-" .Path }}`, "", "nginx:1.25", "user/docker.io-library-nginx:1.25"},
+		{"tag suffix", "", "{{ .Tag }}-mirror", "nginx:1.25", "user/nginx:1.25-mirror"},
+		{"tag from host", "{{ .Namespace }}/{{ .Path | basename }}", `{{ replace "\\." "-" .Host }}-{{ .Tag }}`, "gcr.io/google-containers/pause:3.9", "user/pause:gcr-io-3.9"},
+		{"repo and tag upper", "{{ .Namespace }}/{{ .Repo | lower }}", "{{ .Tag | upper }}", "Example.com/App:v1", "user/example.com/app:V1"},
+		{"host with port", "{{ .Namespace }}/{{ .Path | basename }}", "", "localhost:5000/app:v1", "user/app:v1"},
+		{"drop tag", "{{ .Namespace }}/{{ .Path | basename }}-{{ .Tag }}", "{{ if false }}x{{ end }}", "nginx:1.25", "user/nginx-1.25"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, tag := tt.repo, tt.tag
+			if repo == "" {
+				repo = pflag.Lookup("dest-repo-template").DefValue
+			}
+			if tag == "" {
+				tag = pflag.Lookup("dest-tag-template").DefValue
+			}
+			if got := newTargetNamer(repo, tag, nil, nil).name("user", tt.restore); got != tt.want {
+				t.Errorf("name(%q) = %q, want %q", tt.restore, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetNamerTagReplace(t *testing.T) {
+	tests := []struct {
+		name     string
+		replaces []string
+		restore  string
+		want     string
+	}{
+		{"build metadata", []string{`\+=>-`}, "app:1.2.3+build", "user/app:1.2.3-build"},
+		{"all occurrences", []string{`\+=>-`}, "app:1.2.3+build+42", "user/app:1.2.3-build-42"},
+		{"no match", []string{`\+=>-`}, "app:1.2.3", "user/app:1.2.3"},
+		{"capture groups", []string{`^v(\d+)\.(\d+)=>$1-$2`}, "app:v1.25", "user/app:1-25"},
+		{"applied in order", []string{`\+.*$=>`, `^v=>`}, "app:v1.2.3+build", "user/app:1.2.3"},
+		{"replaced to empty", []string{`.*=>`}, "app:1.0", "user/app"},
+		{"untagged", []string{`^$=>latest`}, "app", "user/app:latest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newTargetNamer(pflag.Lookup("dest-repo-template").DefValue, pflag.Lookup("dest-tag-template").DefValue, tt.replaces, nil)
+			if got := n.name("user", tt.restore); got != tt.want {
+				t.Errorf("name(%q) = %q, want %q", tt.restore, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetNamerTagReplaceInvalid(t *testing.T) {
+	for _, replace := range []string{`\+`, `[=>-`} {
+		message := mustPanic(t, func() { newTargetNamer("{{ .Repo }}", "{{ .Tag }}", []string{replace}, nil) })
+		if !strings.Contains(message, "--tag-regex-replace") {
+			t.Errorf("newTargetNamer(%q) panic = %q", replace, message)
+		}
+	}
+}
+
+func TestTargetNamerHostTemplates(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hosts.yaml")
+	text := `gcr.io:
+  repo: '{{ .Namespace }}/gcr-{{ .Path | basename }}'
+ghcr.io:
+  tag: '{{ .Tag }}-ghcr'
+index.docker.io:
+  repo: '{{ .Namespace }}/hub-{{ .Path | basename }}'
+  tag: 'hub-{{ .Tag }}'
+`
+	if err := os.WriteFile(file, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n := newTargetNamer(pflag.Lookup("dest-repo-template").DefValue, pflag.Lookup("dest-tag-template").DefValue, []string{`\+=>-`}, readHostTemplates(file))
+	tests := []struct{ restore, want string }{
+		{"gcr.io/google-containers/pause:3.9", "user/gcr-pause:3.9"},
+		{"ghcr.io/owner/app:1.0+build", "user/ghcr.io.owner.app:1.0-build-ghcr"},
+		{"nginx:1.25", "user/hub-nginx:hub-1.25"},
+		{"quay.io/prometheus/node-exporter:v1.7.0", "user/quay.io.prometheus.node-exporter:v1.7.0"},
+		{"us.gcr.io/project/app:1", "user/us.gcr.io.project.app:1"},
+	}
+	for _, tt := range tests {
+		if got := n.name("user", tt.restore); got != tt.want {
+			t.Errorf("name(%q) = %q, want %q", tt.restore, got, tt.want)
+		}
+	}
+}
+
+func TestCheckLatest(t *testing.T) {
+	var buf bytes.Buffer
+	checkLatest(&buf, nil, true)
+	if buf.Len() != 0 {
+		t.Errorf("no latest images printed %q", buf.String())
+	}
+
+	latest := []string{"nginx", "redis:latest"}
+	checkLatest(&buf, latest, false)
+	if want := "警告：以下镜像使用 latest tag，建议指定版本：[nginx redis:latest]\n"; buf.String() != want {
+		t.Errorf("warning = %q, want %q", buf.String(), want)
+	}
+
+	// --no-latest 时报错并列出镜像
+	message := mustPanic(t, func() { checkLatest(&buf, latest, true) })
+	if message != "images use the latest tag: [nginx redis:latest]" {
+		t.Errorf("--no-latest panic = %q", message)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// ansiPattern 状态文字的颜色控制码，写入日志文件时去除
+var ansiPattern = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// rotatingFile 追加写入的日志文件，超过 maxSize 时将当前文件重命名为 .1 后重新创建，maxSize 为 0 时不轮转
+type rotatingFile struct {
+	path    string
+	maxSize int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize}
+	return r, r.open()
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p = ansiPattern.ReplaceAll(p, nil)
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		r.f.Close()
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return 0, err
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// teeOutput 将标准输出和标准错误同时写入日志文件，返回的函数恢复标准输出并等待日志写完
+func teeOutput(file string, maxSize int64) func() {
+	log, err := openRotatingFile(file, maxSize)
+	if err != nil {
+		panic(err)
+	}
+	var wg sync.WaitGroup
+	tee := func(target **os.File) *os.File {
+		original := *target
+		r, w, err := os.Pipe()
+		if err != nil {
+			panic(err)
+		}
+		*target = w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			io.Copy(io.MultiWriter(original, log), r)
+		}()
+		return original
+	}
+	stdout := tee(&os.Stdout)
+	stderr := tee(&os.Stderr)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			os.Stdout.Close()
+			os.Stderr.Close()
+			os.Stdout, os.Stderr = stdout, stderr
+			wg.Wait()
+			if err := log.Close(); err != nil {
+				fmt.Fprintln(os.Stderr, "关闭日志文件失败：", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readFile 读取文件内容，文件不存在时返回空字符串
+func readFile(t *testing.T, file string) string {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotatingFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "run.log")
+	if err := os.WriteFile(file, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := openRotatingFile(file, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	write := func(s string) {
+		t.Helper()
+		if n, err := r.Write([]byte(s)); err != nil || n != len(ansiPattern.ReplaceAllString(s, "")) {
+			t.Fatalf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	// 追加到已有的内容后，颜色控制码不写入文件
+	write(colorGreen + "ok" + colorReset + " a\n")
+	if got := readFile(t, file); got != "old\nok a\n" {
+		t.Errorf("log = %q, want the existing content and the stripped line", got)
+	}
+	if _, err := os.Stat(file + ".1"); !os.IsNotExist(err) {
+		t.Error("rotated before reaching maxSize")
+	}
+
+	// 超过 maxSize 时重命名为 .1 并重新创建
+	write("line two\n")
+	if got := readFile(t, file+".1"); got != "old\nok a\n" {
+		t.Errorf("rotated log = %q, want the previous content", got)
+	}
+	if got := readFile(t, file); got != "line two\n" {
+		t.Errorf("log = %q after rotation, want only the new line", got)
+	}
+
+	// 再次轮转时覆盖之前的 .1
+	write("line three\n")
+	if got := readFile(t, file+".1"); got != "line two\n" {
+		t.Errorf("rotated log = %q, want the content before the second rotation", got)
+	}
+	if got := readFile(t, file); got != "line three\n" {
+		t.Errorf("log = %q after the second rotation, want only the newest line", got)
+	}
+
+	// 单次写入超过 maxSize 时仍完整写入当前文件
+	long := strings.Repeat("x", 40) + "\n"
+	write(long)
+	if got := readFile(t, file); got != long {
+		t.Errorf("log = %q, want the oversized write kept whole", got)
+	}
+}
+
+func TestRotatingFileNoLimit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "run.log")
+	r, err := openRotatingFile(file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(r, "line %d\n", i)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file + ".1"); !os.IsNotExist(err) {
+		t.Error("rotated with maxSize 0")
+	}
+	if got := strings.Count(readFile(t, file), "\n"); got != 100 {
+		t.Errorf("log has %d lines, want 100", got)
+	}
+}
+
+// TestTeeOutput 日志文件与标准输出内容相同，只去除颜色控制码，标准错误同样写入日志
+func TestTeeOutput(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "run.log")
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	stderr := os.Stderr
+	os.Stderr = devNull
+	defer func() { os.Stderr = stderr }()
+
+	stdout := captureStdout(t, func() {
+		restore := teeOutput(file, 0)
+		fmt.Println(colorGreen+"转换成功"+colorReset, "nginx:1.25 => user/nginx:1.25")
+		fmt.Println("进度：1/1 完成")
+		fmt.Fprintln(os.Stderr, "警告：stderr line")
+		restore()
+		// 恢复后的输出不再写入日志
+		fmt.Println("after restore")
+	})
+
+	want := colorGreen + "转换成功" + colorReset + " nginx:1.25 => user/nginx:1.25\n进度：1/1 完成\nafter restore\n"
+	if stdout != want {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+	// 标准输出和标准错误分别复制，两者在日志中的先后顺序不固定
+	log := readFile(t, file)
+	if !strings.Contains(log, "警告：stderr line\n") {
+		t.Errorf("log = %q, want the stderr line", log)
+	}
+	stdoutLog := strings.Replace(log, "警告：stderr line\n", "", 1)
+	if want := "转换成功 nginx:1.25 => user/nginx:1.25\n进度：1/1 完成\n"; stdoutLog != want {
+		t.Errorf("log = %q, want stdout without color codes %q", log, want)
+	}
+	if os.Stderr != devNull {
+		t.Error("teeOutput did not restore stderr")
+	}
+}
@@ -1,253 +1,1032 @@
 package main
 
 import (
+	"bufio"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
 	"strings"
 	"sync"
-	"text/template"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"github.com/docker/go-units"
 	"github.com/spf13/pflag"
 )
 
 var (
-	content            = pflag.StringP("content", "", "", "原始镜像，格式为：{ \"hub-mirror\": [] }")
-	maxContent         = pflag.IntP("maxContent", "", 10, "原始镜像个数限制")
-	username           = pflag.StringP("username", "", "", "docker hub 用户名")
-	password           = pflag.StringP("password", "", "", "docker hub 密码")
-	outputPath         = pflag.StringP("outputPath", "", "output.sh", "结果输出路径")
-	customRegistryPath = pflag.StringP("customRegistryPath", "", "cusreg.sh", "自定义镜像仓库结果输出路径")
-	nerdctlPath        = pflag.StringP("nerdctlPath", "", "nerdctl.sh", "nerdctl 命令结果输出路径")
+	content             = pflag.StringP("content", "", "", "原始镜像，格式为：{ \"hub-mirror\": [] }")
+	maxContent          = pflag.IntP("maxContent", "", 10, "原始镜像个数限制")
+	contentFile         = pflag.StringP("contentFile", "", "", "从文件或 http(s):// 地址读取原始镜像，.txt 文件为每行一个镜像，其它为与 --content 相同的 JSON")
+	mergeContents       = pflag.BoolP("merge-content", "", false, "同时指定 --content 和 --contentFile 时合并两者并去重，custom-registry 不一致时报错")
+	contentHeaders      = pflag.StringArrayP("content-header", "", nil, "--contentFile 为 URL 时附加的请求头，如 \"Authorization: Bearer xxx\"，可指定多个")
+	contentFormat       = pflag.StringP("format", "", "", "--contentFile 的格式：json 或 txt，默认按扩展名判断")
+	customRegistry      = pflag.StringP("customRegistry", "", "", "自定义镜像仓库，设置后覆盖原始镜像中的 custom-registry")
+	digestTagLen        = pflag.IntP("digest-tag-length", "", 12, "原始镜像仅有 digest 时，生成 tag 使用的 hash 长度，批次中冲突时自动加长，0 表示完整 hash")
+	onCollision         = pflag.StringP("on-target-collision", "", "error", "不同源镜像映射到同一目标镜像时的处理：error 报错，suffix 在目标仓库名后添加源镜像 hash")
+	noLatest            = pflag.BoolP("no-latest", "", false, "原始镜像未指定 tag 或使用 latest 时报错，默认仅警告")
+	requireCustom       = pflag.BoolP("require-custom-registry", "", false, "未设置 custom-registry 时报错，避免漏生成自定义仓库脚本")
+	sourcePlainHTTP     = pflag.StringSliceP("source-plain-http", "", nil, "使用明文 HTTP 访问的源仓库地址（如 registry.local:5000），用于 tag 列表和预检，可指定多个")
+	allTags             = pflag.StringSliceP("all-tags", "", nil, "转换仓库的所有 tag，可指定多个，也可在原始镜像中写作 repo:*")
+	tagAllow            = pflag.StringP("tag-allow", "", "", "只转换匹配该正则的 tag，作用于展开的 tag 和原始镜像中显式写出的 tag")
+	tagDeny             = pflag.StringP("tag-deny", "", "", "不转换匹配该正则的 tag，如 -(rc|alpha|beta)")
+	maxExpanded         = pflag.IntP("max-expanded", "", 50, "每个仓库展开所有 tag 时最多转换的个数")
+	retryFile           = pflag.StringP("retry-file", "", "", "从之前生成的失败镜像文件读取原始镜像，只重试失败的镜像")
+	failuresFile        = pflag.StringP("failures-file", "", "failures.json", "有镜像转换失败时，将失败的镜像写入该文件，为空时不写入")
+	contentFromHelm     = pflag.StringP("content-from-helm", "", "", "使用 helm template 渲染该 chart，并将其中的镜像加入原始镜像")
+	helmValues          = pflag.StringP("helm-values", "", "", "渲染 --content-from-helm 时使用的 values 文件")
+	contentFromK8s      = pflag.StringSliceP("content-from-k8s", "", nil, "从 Kubernetes 清单文件或目录中提取容器镜像加入原始镜像，可指定多个")
+	username            = pflag.StringP("username", "", "", "docker hub 用户名")
+	password            = pflag.StringP("password", "", "", "docker hub 密码")
+	credentialsFile     = pflag.StringP("credentials-file", "", "", "按仓库地址配置用户名密码的 JSON/YAML 文件，用于拉取和上传，--username/--password 优先")
+	vaultAddr           = pflag.StringP("vault-addr", "", "", "Vault 地址，默认读取 VAULT_ADDR 环境变量")
+	vaultPath           = pflag.StringP("vault-path", "", "", "从该 Vault KV 路径读取认证信息（如 secret/data/hub-mirror），覆盖 --credentials-file 中的同名仓库，token 读取 VAULT_TOKEN")
+	outputPath          = pflag.StringP("outputPath", "", "output.sh", "结果输出路径")
+	customRegistryPath  = pflag.StringP("customRegistryPath", "", "cusreg.sh", "自定义镜像仓库结果输出路径")
+	nerdctlPath         = pflag.StringP("nerdctlPath", "", "nerdctl.sh", "nerdctl 命令结果输出路径")
+	restoreTarget       = pflag.StringP("output-restore-target", "", "source", "output.sh 中 docker tag 还原的镜像：source、custom（自定义仓库）或模板，如 {{ .CustomRegistry }}/{{ .Source }}")
+	serveAddr           = pflag.StringP("serve", "", "", "以 HTTP 服务运行并监听该地址（如 :8080）：POST /jobs 提交原始镜像 JSON，GET /jobs/{id} 查询结果，其它参数应用于每个任务")
+	serveConcurrency    = pflag.IntP("serve-concurrency", "", 1, "--serve 同时执行的任务数")
+	serveDir            = pflag.StringP("serve-dir", "", filepath.Join(os.TempDir(), "hub-mirror-jobs"), "--serve 保存每个任务文件的目录")
+	showVersion         = pflag.BoolP("version", "", false, "打印版本、git commit 和构建时间后退出")
+	validateOnly        = pflag.BoolP("validate-only", "", false, "只校验原始镜像内容（镜像名、maxContent、custom-registry）并报告所有问题，不连接 Docker 和镜像仓库")
+	warmOnly            = pflag.BoolP("warm-only", "", false, "仅拉取镜像（如预热拉取缓存），不重新标签、上传，也不生成脚本，无需用户名密码")
+	noScripts           = pflag.BoolP("no-scripts", "", false, "不生成 output.sh、cusreg.sh、nerdctl.sh，--mapping-json 等其它输出不受影响")
+	ansibleOutput       = pflag.StringP("ansible-output", "", "", "生成 Ansible playbook 的输出路径，使用 community.docker.docker_image 拉取、标签和上传")
+	makefileOutput      = pflag.StringP("makefile-output", "", "", "生成 Makefile 的输出路径，每个镜像一个目标，make all 执行全部")
+	scriptStyleName     = pflag.StringP("script-style", "", "bash", "生成脚本的风格：bash、powershell、cmd")
+	destRepoTemplate    = pflag.StringP("dest-repo-template", "", `{{ .Namespace }}/{{ replace "/" "." .Repo }}`, "目标仓库名模板，可用 .Namespace、.Repo、.Host、.Path、.Tag")
+	destTagTemplate     = pflag.StringP("dest-tag-template", "", "{{ .Tag }}", "目标 tag 模板，渲染为空时不带 tag")
+	hostTemplatesFile   = pflag.StringP("host-templates", "", "", "按源镜像仓库地址覆盖 --dest-repo-template/--dest-tag-template 的 JSON/YAML 文件，格式为 { \"gcr.io\": { \"repo\": \"\", \"tag\": \"\" } }")
+	tagRegexReplace     = pflag.StringArrayP("tag-regex-replace", "", nil, "对目标 tag 做正则替换，格式为 pattern=>replacement，如 '\\+=>-' 将 1.2.3+build 转换为 1.2.3-build，可指定多个，按顺序应用")
+	preserveHost        = pflag.BoolP("preserve-host", "", false, "目标镜像名保留源镜像的完整仓库地址，如 nginx:1.25 => 用户名/docker.io.library.nginx:1.25")
+	destLowercase       = pflag.BoolP("dest-tag-lowercase", "", true, "将目标镜像名（包括 tag）转换为小写，目标仓库支持大写时可设为 false")
+	targetNamespace     = pflag.StringP("target-namespace", "", "", "目标命名空间（如组织名），默认为用户名，仍使用 --username 登录")
+	namespaceLabel      = pflag.StringP("namespace-from-label", "", "", "使用源镜像该 label 的值作为目标命名空间，代替用户名")
+	namespaceDefault    = pflag.StringP("namespace-from-label-default", "", "", "源镜像没有 --namespace-from-label 指定的 label 时使用的命名空间，默认为 --target-namespace 或用户名")
+	confirm             = pflag.BoolP("confirm", "", false, "开始转换前列出待转换镜像并等待确认")
+	yes                 = pflag.BoolP("yes", "", false, "跳过 --confirm 的确认提示")
+	platform            = pflag.StringP("platform", "", "", "拉取指定平台的镜像，格式为 os/arch[/variant]，如 linux/arm64")
+	skipMissingPlatform = pflag.BoolP("skip-missing-platform", "", false, "源镜像不提供 --platform 指定的平台时跳过该镜像，默认报错退出")
+	includeAttestations = pflag.BoolP("include-attestations", "", false, "检查 --platform 时包含 unknown/unknown 平台的证明清单")
+	maxImageSize        = pflag.StringP("max-image-size", "", "", "跳过压缩后大小超过该值的镜像（如 2GB），拉取前通过 manifest 计算")
+	maxAge              = pflag.DurationP("max-age", "", 0, "跳过创建时间早于该时长的源镜像，如 720h，拉取前通过仓库中的 config 判断，默认不限制")
+	mappingJSON         = pflag.StringP("mapping-json", "", "", "转换结果映射（source、target、digest）的 JSON 输出路径")
+	digestMapOutput     = pflag.StringP("digest-map-output", "", "", "源镜像与实际上传的 digest（source、pushed_digest）的 JSON 输出路径")
+	driftBaseline       = pflag.StringP("check-digest-drift", "", "", "与该映射文件（--mapping-json 生成）比较源镜像 tag 当前的 digest 并报告变化，不转换镜像")
+	driftReport         = pflag.StringP("drift-report", "", "drift.json", "--check-digest-drift 的报告输出路径")
+	baseMapping         = pflag.StringP("base-mapping", "", "", "之前生成的映射文件，脚本只输出相对其新增或 digest 变化的镜像")
+	allowlistFile       = pflag.StringP("allowlist-file", "", "", "镜像白名单文件，每行一个镜像或通配符，如 gcr.io/team/*:v1.*")
+	allowlistSkip       = pflag.BoolP("allowlist-skip", "", false, "跳过不在白名单中的镜像，默认报错退出")
+	retries             = pflag.IntP("retries", "", 0, "拉取或上传失败时的重试次数，可在原始镜像对象中用 retries 单独设置")
+	pullTimeout         = pflag.DurationP("pull-timeout", "", 0, "单个镜像拉取的超时时间，0 表示不限制")
+	pushTimeout         = pflag.DurationP("push-timeout", "", 0, "单个镜像上传的超时时间，0 表示不限制")
+	immutableSkip       = pflag.BoolP("treat-immutable-as-skip", "", false, "目标仓库因不可变 tag 规则拒绝上传时记为跳过而不是失败")
+	maxErrors           = pflag.IntP("max-errors", "", 0, "失败的镜像数量超过该值时以非 0 退出，未超过时仅输出警告，负数表示不限制")
+	gcBefore            = pflag.BoolP("gc-before", "", false, "开始转换前清理悬空镜像（docker image prune）")
+	gcAll               = pflag.BoolP("gc-all", "", false, "开始转换前清理所有未被容器使用的镜像和数据卷，包含 --gc-before")
+	imageConcurrency    = pflag.StringP("image-concurrency", "", "0", "同时转换的镜像数，0 表示不限制，auto 表示按 CPU 数自动选择（最多 16）")
+	ramp                = pflag.DurationP("ramp", "", 0, "每隔该时长开始转换下一个镜像（如 300ms），默认同时开始")
+	gracePeriod         = pflag.DurationP("grace-period", "", 30*time.Second, "收到 SIGINT/SIGTERM 后等待进行中的上传完成的最长时间")
+	logFile             = pflag.StringP("log-file", "", "", "同时将所有输出追加写入该日志文件")
+	logMaxSize          = pflag.StringP("log-max-size", "", "", "日志文件超过该大小（如 10MB）时重命名为 .1 并重新创建，默认不轮转")
+	noColor             = pflag.BoolP("no-color", "", false, "不为状态文字着色")
+	forceColor          = pflag.BoolP("force-color", "", false, "即使输出不是终端也为状态文字着色")
+	pushgatewayURL      = pflag.StringP("pushgateway-url", "", "", "结束时将汇总指标推送到该 Prometheus Pushgateway 地址，失败不影响退出码")
+	pushgatewayJob      = pflag.StringP("pushgateway-job", "", "hub-mirror", "推送到 Pushgateway 使用的 job 标签")
+	summaryJSON         = pflag.StringP("summary-json", "", "", "转换结果汇总（数量、大小、耗时、状态）的 JSON 输出路径")
+	preflightOnly       = pflag.BoolP("preflight", "", false, "只检查 Docker 守护进程、目标仓库登录和源仓库连通性后退出，不转换镜像")
+	dockerAPIVersion    = pflag.StringP("docker-api-version", "", "", "固定 Docker API 版本，如 1.41，默认自动协商")
+	watchInterval       = pflag.DurationP("watch", "", 0, "持续运行，每隔该时间检查源镜像 digest，重新转换发生变化的镜像，如 10m，直到被中断")
+	registryHeaders     = pflag.StringArrayP("header", "", nil, "附加到镜像仓库 API 请求（tag 展开、digest、大小查询等）的请求头，格式为 [仓库地址=]Name: value，可指定多个，Docker 守护进程的拉取和上传不受影响")
+	statsInterval       = pflag.DurationP("stats-interval", "", 0, "每隔该时间打印一行进度（完成数、已上传大小、转换中的镜像数），如 30s，适合输出到日志文件时使用")
+	requireDigest       = pflag.BoolP("require-digest", "", false, "要求所有原始镜像都通过 @sha256: 固定 digest，存在可变 tag 时报错退出")
+	dryRun              = pflag.StringP("dry-run", "", "", "只输出转换计划后退出：plan（默认）不访问任何网络，check 只读查询源镜像 digest 和目标镜像是否已存在")
+	listTargetsFormat   = pflag.StringP("list-targets", "", "", "只输出源镜像与目标镜像的对应关系后退出，格式为 table 或 json")
 )
 
+func init() {
+	pflag.Lookup("list-targets").NoOptDefVal = "table"
+	pflag.Lookup("dry-run").NoOptDefVal = dryRunPlan
+}
+
 func main() {
-	pflag.Parse()
+	start := time.Now()
+	defer func() { flushLog() }()
+	// 出错退出时隐藏错误信息中的认证信息，重新 panic 会同时打印原始信息，因此直接退出
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "panic: %s\n\n%s", redact(fmt.Sprint(r)), debug.Stack())
+			exit(2)
+		}
+	}()
 
-	fmt.Println("验证原始镜像内容")
-	var hubMirrors struct {
-		Content []string `json:"hub-mirror"`
-		// CustomRegistry 自定义镜像仓库
-		CustomRegistry string `json:"custom-registry"`
+	pflag.Parse()
+	applyEnv(pflag.CommandLine)
+	if *showVersion {
+		fmt.Println(currentBuild())
+		return
 	}
-	err := json.Unmarshal([]byte(*content), &hubMirrors)
-	if err != nil {
-		panic(err)
+	if *serveAddr != "" {
+		style, ok := scriptStyles[*scriptStyleName]
+		if !ok {
+			panic("unknown script style: " + *scriptStyleName)
+		}
+		addSecret(*password)
+		panic(serve(*serveAddr, *serveDir, *serveConcurrency, style))
 	}
-	if len(hubMirrors.Content) > *maxContent {
-		panic("content is too long.")
+	// --list-targets 的结果会被 jq 等工具解析，标准输出只保留结果，其它输出改写到标准错误
+	targetsOut := os.Stdout
+	if *listTargetsFormat != "" {
+		os.Stdout = os.Stderr
+	}
+	if *noColor {
+		useColor = false
+	} else if *forceColor {
+		useColor = true
 	}
-	fmt.Printf("%+v\n", hubMirrors)
 
-	fmt.Println("连接 Docker")
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		panic(err)
+	style, ok := scriptStyles[*scriptStyleName]
+	if !ok {
+		panic("unknown script style: " + *scriptStyleName)
 	}
 
-	fmt.Println("验证 Docker 用户名密码")
-	if *username == "" || *password == "" {
-		panic("username or password cannot be empty.")
+	if *logFile != "" {
+		var maxSize int64
+		if *logMaxSize != "" {
+			size, err := units.RAMInBytes(*logMaxSize)
+			if err != nil {
+				panic(err)
+			}
+			maxSize = size
+		}
+		flushLog = teeOutput(*logFile, maxSize)
 	}
-	authConfig := types.AuthConfig{
-		Username: *username,
-		Password: *password,
+
+	checkDryRunMode(*dryRun)
+
+	credentials := make(map[string]registryCredential)
+	if *credentialsFile != "" {
+		credentials = readCredentials(*credentialsFile)
 	}
-	encodedJSON, err := json.Marshal(authConfig)
-	if err != nil {
-		panic(err)
+	if *vaultPath != "" {
+		addr := *vaultAddr
+		if addr == "" {
+			addr = os.Getenv("VAULT_ADDR")
+		}
+		for host, credential := range readVaultCredentials(addr, *vaultPath, os.Getenv("VAULT_TOKEN")) {
+			credentials[host] = credential
+		}
 	}
-	authStr := base64.URLEncoding.EncodeToString(encodedJSON)
-	_, err = cli.RegistryLogin(context.Background(), authConfig)
-	if err != nil {
-		panic(err)
+	dest := destCredential(credentials, *username, *password)
+	addSecret(dest.Password, dest.Token)
+	namespace := targetNamespaceFor(dest.Username, *targetNamespace)
+	if *namespaceDefault != "" && !namespacePattern.MatchString(*namespaceDefault) {
+		panic("invalid --namespace-from-label-default: " + *namespaceDefault)
+	}
+	for host, credential := range credentials {
+		addSecret(credential.Password, credential.Token, encodeAuth(credential.authConfig(host)))
 	}
 
-	fmt.Println("开始转换镜像")
-	output := make([]struct {
-		Source string
-		Target string
-	}, 0)
-
-	wg := sync.WaitGroup{}
-
-	for _, source := range hubMirrors.Content {
-		if source == "" {
+	fmt.Println("验证原始镜像内容")
+	var hubMirrors mirrorContent
+	switch {
+	case *mergeContents && *contentFile != "" && *content != "":
+		var inline mirrorContent
+		err := json.Unmarshal([]byte(*content), &inline)
+		if err != nil {
+			panic(err)
+		}
+		hubMirrors = mergeContent(inline, readContentFile(*contentFile, *contentFormat, *contentHeaders))
+	case *contentFile != "":
+		if *content != "" {
+			panic("--content and --contentFile cannot be used together, use --merge-content to merge them.")
+		}
+		hubMirrors = readContentFile(*contentFile, *contentFormat, *contentHeaders)
+	case *retryFile != "":
+		hubMirrors = readContentFile(*retryFile, "json", nil)
+	case *content != "" || (*contentFromHelm == "" && len(*contentFromK8s) == 0):
+		err := json.Unmarshal([]byte(*content), &hubMirrors)
+		if err != nil {
+			panic(err)
+		}
+	}
+	hubMirrors.CustomRegistry = resolveCustomRegistry(hubMirrors.CustomRegistry, *customRegistry, *requireCustom)
+	if *contentFromHelm != "" {
+		for _, image := range helmImages(*contentFromHelm, *helmValues) {
+			hubMirrors.Content = append(hubMirrors.Content, contentEntry{Image: image})
+		}
+	}
+	if len(*contentFromK8s) > 0 {
+		for _, image := range k8sImages(*contentFromK8s) {
+			hubMirrors.Content = append(hubMirrors.Content, contentEntry{Image: image})
+		}
+	}
+	var pinProblems []string
+	if *requireDigest {
+		pinProblems = unpinnedImages(hubMirrors, *allTags)
+	}
+	if *validateOnly {
+		problems := append(validateContent(hubMirrors, *allTags, *maxContent), pinProblems...)
+		for _, problem := range problems {
+			fmt.Println("校验失败", problem)
+		}
+		if len(problems) > 0 {
+			exit(1)
+		}
+		fmt.Println("原始镜像内容校验通过，共", len(hubMirrors.Content)+len(*allTags), "个镜像")
+		return
+	}
+	if len(pinProblems) > 0 {
+		for _, problem := range pinProblems {
+			fmt.Println("校验失败", problem)
+		}
+		exit(1)
+	}
+	// 展开 repo:* 和 --all-tags 指定的仓库
+	if len(*sourcePlainHTTP) > 0 {
+		fmt.Fprintf(os.Stderr, "警告：以下仓库使用明文 HTTP 访问，拉取时仍需在 Docker 守护进程中配置 insecure-registries：%s\n", strings.Join(*sourcePlainHTTP, ", "))
+	}
+	rc := newRegistryClient(credentials, *sourcePlainHTTP)
+	rc.addHeaders(*registryHeaders)
+	filter := newTagFilter(*tagAllow, *tagDeny)
+	expanded := make([]contentEntry, 0, len(hubMirrors.Content))
+	for _, entry := range hubMirrors.Content {
+		name, digest := splitDigest(entry.Image)
+		repo, tag := splitTag(name)
+		if tag == "*" && *dryRun == dryRunPlan {
+			panic(entry.Image + " requires registry access to expand tags, use --dry-run=check")
+		}
+		if tag != "*" {
+			// 只写 digest 的镜像不按 tag 过滤，没写 tag 的按 latest 过滤
+			if tag == "" && digest == "" {
+				tag = "latest"
+			}
+			if tag != "" && !filter.match(tag) {
+				fmt.Println("跳过 tag 未通过过滤的镜像", entry.Image)
+				continue
+			}
+			expanded = append(expanded, entry)
 			continue
 		}
+		for _, image := range expandTags(context.Background(), rc, repo, *maxExpanded, filter) {
+			expanded = append(expanded, contentEntry{Image: image})
+		}
+	}
+	for _, repo := range *allTags {
+		for _, image := range expandTags(context.Background(), rc, repo, *maxExpanded, filter) {
+			expanded = append(expanded, contentEntry{Image: image})
+		}
+	}
+	hubMirrors.Content = expanded
 
-		index := strings.Index(source, "@sha256")
-		var target string
-		if index != -1 {
-			// 去除 @sha256，将后面的 hash 作为 tag
-			cleaned := strings.Replace(source, "@sha256", "", 1)
-			target = *username + "/" + strings.ReplaceAll(cleaned, "/", ".")
-		} else {
-			target = *username + "/" + strings.ReplaceAll(source, "/", ".")
+	if len(hubMirrors.Content) > *maxContent {
+		panic("content is too long.")
+	}
+	fmt.Printf("%+v\n", hubMirrors)
+
+	output := make([]mirrorOutput, 0)
+	results := make([]mirrorResult, 0)
+
+	mu := sync.Mutex{}
+	// recordResult 记录转换结果，mirrored 为 true 时目标镜像已存在（转换成功或目标 tag 不可变），
+	// 写入脚本和映射
+	recordResult := func(plan mirrorOutput, status string, size int64, mirrored bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if mirrored {
+			output = append(output, plan)
 		}
+		results = append(results, mirrorResult{
+			Source: plan.Pull,
+			Target: plan.Target,
+			Status: status,
+			Size:   size,
+		})
+	}
+	record := func(plan mirrorOutput, status string, size int64) {
+		recordResult(plan, status, size, status == statusSuccess)
+	}
+	failures := make([]contentEntry, 0)
+	recordFailure := func(plan mirrorOutput, reason string) {
+		mu.Lock()
+		defer mu.Unlock()
+		failures = append(failures, planEntry(plan, *retries))
+		results = append(results, mirrorResult{
+			Source: plan.Pull,
+			Target: plan.Target,
+			Status: statusFailed,
+			Error:  reason,
+		})
+	}
 
-		wg.Add(1)
-		go func(source, target string) {
-			defer wg.Done()
+	var allowlist []string
+	if *allowlistFile != "" {
+		allowlist = readLines(*allowlistFile)
+	}
 
-			fmt.Println("开始转换", source, "=>", target)
-			ctx := context.Background()
+	sources := make([]string, 0, len(hubMirrors.Content))
+	for _, entry := range hubMirrors.Content {
+		sources = append(sources, entry.Image)
+	}
+	digestLength := digestTagLength(sources, *digestTagLen)
+	if digestLength != *digestTagLen {
+		fmt.Println("digest 截断后冲突，生成 tag 时使用", digestLength, "位 hash")
+	}
 
-			// 拉取镜像
-			pullOut, err := cli.ImagePull(ctx, source, types.ImagePullOptions{})
-			if err != nil {
-				panic(err)
-			}
-			defer pullOut.Close()
-			io.Copy(os.Stdout, pullOut)
+	var hostTemplates map[string]hostTemplate
+	if *hostTemplatesFile != "" {
+		hostTemplates = readHostTemplates(*hostTemplatesFile)
+	}
+	namer := newTargetNamer(*destRepoTemplate, *destTagTemplate, *tagRegexReplace, hostTemplates)
+	planned := planMirrors(hubMirrors.Content, namer, planOptions{
+		namespace:      namespace,
+		customRegistry: hubMirrors.CustomRegistry,
+		digestLength:   digestLength,
+		preserveHost:   *preserveHost,
+		lowercase:      *destLowercase,
+		retries:        *retries,
+		allowlist:      allowlist,
+	})
+	plans, latest, unapproved := planned.plans, planned.latest, planned.unapproved
+	for _, skip := range planned.skips {
+		fmt.Println(statusText("跳过转换"), skip.plan.Image, skip.reason)
+		record(skip.plan, statusSkipped, 0)
+	}
 
-			// 重新标签
-			err = cli.ImageTag(ctx, source, target)
-			if err != nil {
-				panic(err)
+	// 不同源镜像映射到同一目标镜像时，后上传的会覆盖先上传的
+	if collided := targetCollisions(plans); len(collided) > 0 {
+		switch *onCollision {
+		case "error":
+			panic(fmt.Sprintf("different images map to the same target: %v", collided))
+		case "suffix":
+			for i := range plans {
+				if _, ok := collided[plans[i].Target]; ok {
+					target := disambiguate(plans[i].Target, plans[i].Source)
+					if *destLowercase {
+						target = strings.ToLower(target)
+					}
+					fmt.Fprintf(os.Stderr, "警告：目标镜像冲突，%s 改为 %s\n", plans[i].Source, target)
+					plans[i].Target = target
+				}
 			}
+		default:
+			panic(fmt.Sprintf("unknown --on-target-collision %q, expected error or suffix", *onCollision))
+		}
+	}
 
-			// 上传镜像
-			pushOut, err := cli.ImagePush(ctx, target, types.ImagePushOptions{
-				RegistryAuth: authStr,
-			})
-			if err != nil {
-				panic(err)
-			}
-			defer pushOut.Close()
-			io.Copy(os.Stdout, pushOut)
+	checkLatest(os.Stderr, latest, *noLatest)
 
-			output = append(output, struct {
-				Source string
-				Target string
-			}{
-				Source: strings.Replace(source, "@sha256", "", 1), 
-				Target: target,
-			})
-			fmt.Println("转换成功", source, "=>", target)
-		}(source, target)
+	if len(unapproved) > 0 {
+		if !*allowlistSkip {
+			panic(fmt.Sprintf("images not in allowlist: %v", unapproved))
+		}
+		for _, source := range unapproved {
+			fmt.Println(statusText("跳过转换"), source, "不在白名单中")
+			pull, restore := parseSource(source, digestLength)
+			record(mirrorOutput{Pull: pull, Source: restore}, statusSkipped, 0)
+		}
 	}
 
-	wg.Wait()
+	if *listTargetsFormat != "" {
+		listTargets(targetsOut, *listTargetsFormat, plans)
+		return
+	}
 
-	if len(output) == 0 {
-		panic("output is empty.")
+	switch *dryRun {
+	case dryRunPlan:
+		listTargets(os.Stdout, "table", plans)
+		return
+	case dryRunCheck:
+		if !checkPlans(context.Background(), os.Stdout, rc, plans) {
+			exit(1)
+		}
+		return
 	}
 
-	// 创建基础输出文件（docker pull 和 docker tag）
-	outputFile, err := os.Create(*outputPath)
-	if err != nil {
-		panic(err)
+	// 只检查源镜像 tag 的 digest 是否变化，不转换
+	if *driftBaseline != "" {
+		entries := checkDrift(context.Background(), rc, plans, readMapping(*driftBaseline))
+		if writeDrift(*driftReport, entries) {
+			exit(1)
+		}
+		fmt.Println("源镜像 digest 与基准一致")
+		return
 	}
-	defer outputFile.Close()
 
-	// 基础模板：docker pull 和 docker tag
-	tmpl, err := template.New("pull_images").Parse(`{{- range . -}}
+	if *confirm && !*yes && stdoutTerminal {
+		// 镜像大小按 manifest 估算，此时尚未连接 Docker，未指定 --platform 时按 linux/amd64 估算
+		sizePlatform := *platform
+		if sizePlatform == "" {
+			sizePlatform = "linux/amd64"
+		}
+		sizeOf := func(image string) (int64, error) {
+			return rc.imageSize(context.Background(), image, sizePlatform)
+		}
+		if !confirmPlans(os.Stdin, os.Stdout, plans, sizeOf) {
+			fmt.Println("已取消")
+			exit(1)
+		}
+	}
 
-docker pull {{ .Target }}
-docker tag {{ .Target }} {{ .Source }}
+	// 持续转换：每轮以子进程转换 digest 发生变化的镜像
+	if *watchInterval > 0 {
+		watchPlans(*watchInterval, rc, plans, hubMirrors.CustomRegistry, *retries, style)
+		return
+	}
 
-{{ end -}}`)
-	if err != nil {
-		panic(err)
+	fmt.Println("连接 Docker")
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if *dockerAPIVersion != "" {
+		opts = []client.Opt{client.FromEnv, client.WithVersion(*dockerAPIVersion)}
 	}
-	err = tmpl.Execute(outputFile, output)
+	cli, err := client.NewClientWithOpts(opts...)
 	if err != nil {
 		panic(err)
 	}
 
-	// 如果 CustomRegistry 不为空，创建自定义仓库文件
-	if hubMirrors.CustomRegistry != "" {
-		// 创建包含 CustomRegistry 的数据结构
-		type CustomData struct {
-			Output []struct {
-				Source string
-				Target string
-			}
-			CustomRegistry string
-		}
-		customData := CustomData{
-			Output:         output,
-			CustomRegistry: hubMirrors.CustomRegistry,
+	fmt.Println("验证 Docker 用户名密码")
+	if !*preflightOnly && !*warmOnly && (dest.Username == "" || (dest.Password == "" && dest.Token == "")) {
+		panic("username or password cannot be empty.")
+	}
+	authConfig := dest.authConfig("")
+	authStr := encodeAuth(authConfig)
+	addSecret(authStr)
+	if *preflightOnly {
+		if !preflight(cli, authConfig, rc, plans) {
+			exit(1)
 		}
-
-		// 创建自定义仓库输出文件
-		customRegistryFile, err := os.Create(*customRegistryPath)
+		return
+	}
+	if !*warmOnly {
+		_, err = cli.RegistryLogin(context.Background(), authConfig)
 		if err != nil {
 			panic(err)
 		}
-		defer customRegistryFile.Close()
+	}
+
+	// 收到 SIGINT/SIGTERM 时停止拉取新镜像，进行中的上传最多再等待 gracePeriod
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	ctx, drainCtx, stopDrain := drainOnSignal(signals, *gracePeriod)
+	defer stopDrain()
 
-		// 自定义仓库模板
-		customTmpl, err := template.New("custom_registry").Parse(`{{- range .Output -}}
+	// 磁盘空间不足时取消其它镜像的拉取，不再开始新的拉取
+	var diskFull int32
+	// 所有镜像实际上传的字节数
+	var pushedBytes int64
+	pullCtx, cancelPulls := context.WithCancel(ctx)
+	defer cancelPulls()
+	stopOnNoSpace := func() {
+		if atomic.CompareAndSwapInt32(&diskFull, 0, 1) {
+			cancelPulls()
+			reportNoSpace(cli)
+		}
+	}
 
-docker tag {{ .Target }} {{ $.CustomRegistry }}/{{ .Source }}
-docker push {{ $.CustomRegistry }}/{{ .Source }}
+	if *gcBefore || *gcAll {
+		fmt.Println("清理无用镜像")
+		pruneImages(ctx, cli, *gcAll)
+	}
 
-{{ end -}}`)
+	// 按 manifest 中的大小和 config 中的创建时间过滤镜像，未指定 --platform 时按 Docker 守护进程的平台选择
+	var maxSize int64
+	if *maxImageSize != "" {
+		maxSize, err = units.RAMInBytes(*maxImageSize)
 		if err != nil {
 			panic(err)
 		}
-
-		// 执行自定义仓库模板
-		err = customTmpl.Execute(customRegistryFile, customData)
+	}
+	sizePlatform := *platform
+	if sizePlatform == "" && (maxSize > 0 || *maxAge > 0) {
+		server, err := cli.ServerVersion(ctx)
 		if err != nil {
 			panic(err)
 		}
+		sizePlatform = server.Os + "/" + server.Arch
+	}
 
-		// 创建 nerdctl 输出文件
-		nerdctlFile, err := os.Create(*nerdctlPath)
-		if err != nil {
-			panic(err)
+	// 限制同时转换的镜像数
+	concurrency := parseConcurrency(*imageConcurrency)
+	var slots chan struct{}
+	if concurrency > 0 {
+		slots = make(chan struct{}, concurrency)
+	}
+
+	// 同一源镜像多次出现时，manifest 只查询一次
+	distributions := newDistributionCache(cli, credentials)
+
+	// 定期打印进度
+	var inFlight int64
+	stopStats := func() {}
+	if *statsInterval > 0 {
+		mu.Lock()
+		total := len(results) + len(plans)
+		mu.Unlock()
+		stopStats = startStats(*statsInterval, func() progressStats {
+			mu.Lock()
+			defer mu.Unlock()
+			return progressStats{
+				Done:     len(results),
+				Total:    total,
+				InFlight: atomic.LoadInt64(&inFlight),
+				Bytes:    atomic.LoadInt64(&pushedBytes),
+			}
+		})
+	}
+
+	// labelTargets 按 label 确定命名空间后的目标镜像及其源镜像，用于检查转换时才确定的目标是否冲突
+	labelTargets := make(map[string]string)
+
+	// mirror 转换单个镜像，跳过或中断时自行记录结果并返回 nil，出错时返回错误由调用方记录为失败
+	mirror := func(plan *mirrorOutput) error {
+		source, target := plan.Pull, plan.Target
+		// 原始镜像中的 timeout 限制该镜像转换（含重试）的总时间，各阶段仍受 --pull-timeout 和 --push-timeout 限制
+		imgCtx, imgPullCtx, imgDrainCtx := ctx, pullCtx, drainCtx
+		if plan.Timeout > 0 {
+			deadline := time.Now().Add(plan.Timeout)
+			var cancelImg, cancelImgPull, cancelImgDrain context.CancelFunc
+			imgCtx, cancelImg = context.WithDeadline(ctx, deadline)
+			defer cancelImg()
+			imgPullCtx, cancelImgPull = context.WithDeadline(pullCtx, deadline)
+			defer cancelImgPull()
+			imgDrainCtx, cancelImgDrain = context.WithDeadline(drainCtx, deadline)
+			defer cancelImgDrain()
+		}
+		// imageTimedOut 判断是否因超过该镜像的总超时而失败
+		imageTimedOut := func(c context.Context) error {
+			if plan.Timeout > 0 && c.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("%s timed out after %s (per-image timeout)", source, plan.Timeout)
+			}
+			return nil
+		}
+		fmt.Println("开始转换", source, "=>", target)
+		interrupted := func() bool {
+			if ctx.Err() == nil && drainCtx.Err() == nil {
+				return false
+			}
+			fmt.Println(statusText("转换中断"), source, "=>", target)
+			recordFailure(*plan, "interrupted")
+			return true
+		}
+		// 等待 ramp 或并发槽位期间可能已收到中断信号，此时不再查询源镜像
+		if interrupted() {
+			return nil
 		}
-		defer nerdctlFile.Close()
 
-		// nerdctl 模板
-		nerdctlTmpl, err := template.New("nerdctl").Parse(`{{- range .Output -}}
+		// 检查源镜像是否提供指定平台
+		if *platform != "" {
+			err := checkPlatform(imgCtx, distributions, source, *platform, *includeAttestations)
+			if err != nil {
+				if *skipMissingPlatform {
+					fmt.Println(statusText("跳过转换"), source, redact(err.Error()))
+					record(*plan, statusSkipped, 0)
+					return nil
+				}
+				return err
+			}
+		}
 
-nerdctl -n k8s.io pull {{ $.CustomRegistry }}/{{ .Source }}
-nerdctl -n k8s.io tag {{ $.CustomRegistry }}/{{ .Source }} {{ .Source }}
+		// 跳过超过大小限制的镜像
+		if maxSize > 0 {
+			size, err := rc.imageSize(imgCtx, source, sizePlatform)
+			if err != nil {
+				fmt.Println("警告：无法获取镜像大小，继续转换", source, redact(err.Error()))
+			} else if size > maxSize {
+				fmt.Println(statusText("跳过转换"), source, "大小", units.BytesSize(float64(size)), "超过", *maxImageSize)
+				record(*plan, statusTooLarge, size)
+				return nil
+			}
+		}
 
-{{ end -}}`)
-		if err != nil {
-			panic(err)
+		// 拉取前跳过创建时间过早的镜像，无法从仓库获取时拉取后按本地镜像判断
+		ageChecked := false
+		if *maxAge > 0 {
+			created, err := rc.imageCreated(imgCtx, source, sizePlatform)
+			if err != nil {
+				fmt.Println("警告：无法从仓库获取创建时间，拉取后再检查", source, redact(err.Error()))
+			} else {
+				ageChecked = true
+				if old, known := imageTooOld(created, *maxAge, time.Now()); !known {
+					fmt.Println("警告：无法确定创建时间，继续转换", source)
+				} else if old {
+					fmt.Println(statusText("跳过转换"), source, "创建于", created.Format(time.RFC3339), "超过", *maxAge)
+					record(*plan, statusSkipped, 0)
+					return nil
+				}
+			}
 		}
 
-		// 执行 nerdctl 模板
-		err = nerdctlTmpl.Execute(nerdctlFile, customData)
+		// 拉取镜像
+		if atomic.LoadInt32(&diskFull) == 1 {
+			fmt.Println(statusText("跳过转换"), source, "磁盘空间不足")
+			recordFailure(*plan, "no space left on device")
+			return nil
+		}
+		pullStart := time.Now()
+		pullAuth := sourceAuth(credentials, source)
+		timeout := *pullTimeout
+		err := withRetries(imgPullCtx, plan.Retries, source, func() error {
+			stageCtx, cancelStage := stageContext(imgPullCtx, timeout)
+			defer cancelStage()
+			pullOut, err := cli.ImagePull(stageCtx, source, types.ImagePullOptions{
+				Platform:     *platform,
+				RegistryAuth: pullAuth,
+			})
+			if err == nil {
+				defer pullOut.Close()
+				err = copyStream(os.Stdout, pullOut, nil)
+			}
+			if err != nil && stageCtx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("pull timed out after %s", timeout)
+			}
+			return err
+		})
+		if interrupted() {
+			return nil
+		}
 		if err != nil {
-			panic(err)
+			if timedOut := imageTimedOut(imgPullCtx); timedOut != nil {
+				return timedOut
+			}
+			if isNoSpace(err) {
+				stopOnNoSpace()
+			}
+			if atomic.LoadInt32(&diskFull) == 1 {
+				fmt.Println(statusText("转换失败"), source, "磁盘空间不足：", redact(err.Error()))
+				recordFailure(*plan, "no space left on device")
+				return nil
+			}
+			// 多平台镜像中没有 Docker 守护进程的平台时，列出可选平台
+			if isNoMatchingManifest(err) {
+				if available, inspectErr := availablePlatforms(imgCtx, distributions, source, *includeAttestations); inspectErr == nil && len(available) > 0 {
+					return fmt.Errorf("%v; available platforms: %v; use --platform to select one", err, available)
+				}
+			}
+			return err
 		}
-	} else {
-		// 创建 nerdctl 输出文件
-		nerdctlFile, err := os.Create(*nerdctlPath)
+		plan.PullMs = time.Since(pullStart).Milliseconds()
+
+		inspect, _, err := cli.ImageInspectWithRaw(imgDrainCtx, source)
 		if err != nil {
-			panic(err)
+			return err
+		}
+		plan.Digest = repoDigest(source, inspect.RepoDigests)
+		if err := verifyDigest(source, plan.ExpectedDigest, plan.Digest); err != nil {
+			return err
 		}
-		defer nerdctlFile.Close()
-		
-		// nerdctl 模板
-		nerdctlTmpl, err := template.New("nerdctl").Parse(`{{- range .Output -}}
 
-nerdctl -n k8s.io pull {{ .Target }}
-nerdctl -n k8s.io tag {{ .Target }} {{ .Source }}
+		// 仅预热时拉取完成即结束
+		if *warmOnly {
+			record(*plan, statusSuccess, inspect.Size)
+			fmt.Println(statusText("转换成功"), source, "已拉取")
+			return nil
+		}
 
-{{ end -}}`)
+		// 按源镜像的 label 确定目标命名空间
+		if *namespaceLabel != "" {
+			labelNamespace := *namespaceDefault
+			if labelNamespace == "" {
+				labelNamespace = namespace
+			}
+			if inspect.Config != nil && inspect.Config.Labels[*namespaceLabel] != "" {
+				value := inspect.Config.Labels[*namespaceLabel]
+				if namespacePattern.MatchString(value) {
+					labelNamespace = value
+				} else {
+					fmt.Fprintf(os.Stderr, "警告：%s 的 label %s=%q 不是有效的命名空间，使用 %s\n", source, *namespaceLabel, value, labelNamespace)
+				}
+			}
+			name := plan.Source
+			if *preserveHost {
+				name = qualifiedName(plan.Source)
+			}
+			target = namer.name(labelNamespace, name)
+			if *destLowercase {
+				target = strings.ToLower(target)
+			}
+			// 转换时才确定的目标同样检查是否为源镜像本身、是否与其它源镜像冲突
+			if qualifiedName(plan.Source) == qualifiedName(target) {
+				fmt.Println(statusText("跳过转换"), source, "目标镜像与源镜像相同")
+				record(*plan, statusSkipped, 0)
+				return nil
+			}
+			mu.Lock()
+			owner, claimed := labelTargets[target]
+			if !claimed {
+				labelTargets[target] = plan.Source
+			}
+			mu.Unlock()
+			if claimed && owner != plan.Source {
+				if *onCollision != "suffix" {
+					return fmt.Errorf("%s and %s map to the same target %s", owner, plan.Source, target)
+				}
+				suffixed := disambiguate(target, plan.Source)
+				if *destLowercase {
+					suffixed = strings.ToLower(suffixed)
+				}
+				fmt.Fprintf(os.Stderr, "警告：目标镜像冲突，%s 改为 %s\n", plan.Source, suffixed)
+				target = suffixed
+			}
+			plan.Target = target
+		}
+
+		// 拉取前未能检查创建时间时，按本地镜像判断
+		if *maxAge > 0 && !ageChecked {
+			created, _ := time.Parse(time.RFC3339Nano, inspect.Created)
+			if old, known := imageTooOld(created, *maxAge, time.Now()); !known {
+				fmt.Println("警告：无法确定创建时间，继续转换", source, inspect.Created)
+			} else if old {
+				fmt.Println(statusText("跳过转换"), source, "创建于", inspect.Created, "超过", *maxAge)
+				record(*plan, statusSkipped, 0)
+				return nil
+			}
+		}
+
+		// 重新标签
+		tagStart := time.Now()
+		err = cli.ImageTag(imgDrainCtx, source, target)
 		if err != nil {
-			panic(err)
+			return err
 		}
+		plan.TagMs = time.Since(tagStart).Milliseconds()
 
-		// 执行 nerdctl 模板
-		err = nerdctlTmpl.Execute(nerdctlFile, output)
+		// 上传镜像
+		pushStart := time.Now()
+		timeout = *pushTimeout
+		var counter *pushCounter
+		err = withRetries(imgCtx, plan.Retries, target, func() error {
+			pushCtx, cancelPush := stageContext(imgDrainCtx, timeout)
+			defer cancelPush()
+			counter = newPushCounter()
+			pushOut, err := cli.ImagePush(pushCtx, target, types.ImagePushOptions{
+				RegistryAuth: authStr,
+			})
+			if err == nil {
+				defer pushOut.Close()
+				err = copyStream(os.Stdout, pushOut, counter.observe)
+			}
+			if err != nil && pushCtx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("push timed out after %s", timeout)
+			}
+			return err
+		})
+		if drainCtx.Err() != nil {
+			interrupted()
+			return nil
+		}
 		if err != nil {
-			panic(err)
+			if interrupted() {
+				return nil
+			}
+			if timedOut := imageTimedOut(imgDrainCtx); timedOut != nil {
+				return timedOut
+			}
+			if *immutableSkip && isImmutableTag(err) {
+				fmt.Println(statusText("跳过转换"), target, "目标 tag 不可变，视为已转换：", redact(err.Error()))
+				// 未上传时 --digest-map-output 记录目标 tag 现有的 digest，查询失败时不写入该镜像
+				if digest, err := rc.digest(imgDrainCtx, target); err == nil {
+					plan.PushedDigest = digest
+				} else {
+					fmt.Println("警告：无法获取目标镜像的 digest", target, redact(err.Error()))
+				}
+				recordResult(*plan, statusSkipped, 0, true)
+				return nil
+			}
+			return err
+		}
+		plan.PushMs = time.Since(pushStart).Milliseconds()
+		atomic.AddInt64(&pushedBytes, counter.bytes())
+		plan.PushedDigest = counter.digest
+		if plan.PushedDigest == "" {
+			plan.PushedDigest = pushedDigest(imgDrainCtx, cli, target)
+		}
+
+		record(*plan, statusSuccess, inspect.Size)
+		fmt.Println(statusText("转换成功"), source, "=>", target)
+		return nil
+	}
+
+	fmt.Println("开始转换镜像")
+	runPlans(ctx, plans, *ramp, slots, &inFlight, mirror, recordFailure)
+	stopStats()
+
+	runSummary := summarize(results, time.Since(start))
+	runSummary.PushedBytes = atomic.LoadInt64(&pushedBytes)
+	if *summaryJSON != "" {
+		writeSummary(*summaryJSON, runSummary)
+	}
+	if *pushgatewayURL != "" {
+		if err := pushMetrics(*pushgatewayURL, *pushgatewayJob, runSummary); err != nil {
+			fmt.Fprintln(os.Stderr, "警告：推送指标到 Pushgateway 失败：", redact(err.Error()))
+		}
+	}
+	if len(failures) > 0 && *failuresFile != "" {
+		writeContent(*failuresFile, mirrorContent{
+			Content:        failures,
+			CustomRegistry: hubMirrors.CustomRegistry,
+		})
+		fmt.Println("失败的镜像已写入", *failuresFile, "，可通过 --retry-file 重试")
+	}
+
+	// 仅预热时不生成脚本
+	if *warmOnly {
+		if ctx.Err() != nil {
+			exit(1)
 		}
+		if failed := summarize(results, 0).Failed; failed > 0 {
+			fmt.Fprintln(os.Stderr, failed, "个镜像拉取失败")
+			if tooManyErrors(failed, *maxErrors) {
+				exit(1)
+			}
+		}
+		return
 	}
 
+	if len(output) == 0 {
+		if ctx.Err() != nil {
+			fmt.Println("转换中断，没有已完成的镜像")
+			exit(1)
+		}
+		// 所有镜像都被跳过时没有需要生成的脚本，不视为错误
+		failed := summarize(results, 0).Failed
+		if failed == 0 {
+			fmt.Println("所有镜像均已跳过，不生成脚本")
+			return
+		}
+		fmt.Fprintln(os.Stderr, failed, "个镜像转换失败，没有已完成的镜像")
+		if tooManyErrors(failed, *maxErrors) {
+			exit(1)
+		}
+		return
+	}
+
+	output = writeOutputs(output, hubMirrors.CustomRegistry, style)
+
 	fmt.Println(output)
+
+	if ctx.Err() != nil {
+		fmt.Println("转换中断，已输出完成的", len(output), "个镜像")
+		exit(1)
+	}
+	if failed := summarize(results, 0).Failed; failed > 0 {
+		fmt.Fprintln(os.Stderr, failed, "个镜像转换失败")
+		if tooManyErrors(failed, *maxErrors) {
+			exit(1)
+		}
+	}
+}
+
+// writeOutputs 按参数写出映射文件和各种脚本，返回脚本中输出的结果（指定 --base-mapping 时只有新增或变化的镜像）
+func writeOutputs(output []mirrorOutput, customRegistry string, style scriptStyle) []mirrorOutput {
+	if *mappingJSON != "" {
+		writeMapping(*mappingJSON, output)
+	}
+	if *digestMapOutput != "" {
+		writeDigestMap(*digestMapOutput, output)
+	}
+	if *baseMapping != "" {
+		output = diffMapping(output, readMapping(*baseMapping))
+		fmt.Println("相对", *baseMapping, "新增或变化的镜像", len(output), "个")
+	}
+
+	restoreNames(output, *restoreTarget, customRegistry)
+	data := scriptData{
+		Output:         output,
+		CustomRegistry: customRegistry,
+	}
+
+	if !*noScripts {
+		// 基础输出文件：docker pull 和 docker tag
+		writeScript(scriptPath("outputPath", *outputPath, style), "pull_images", pullTemplate, style, data)
+
+		// 如果 CustomRegistry 不为空，创建自定义仓库文件
+		if customRegistry != "" {
+			writeScript(scriptPath("customRegistryPath", *customRegistryPath, style), "custom_registry", customRegistryTemplate, style, data)
+			writeScript(scriptPath("nerdctlPath", *nerdctlPath, style), "nerdctl", nerdctlCustomTemplate, style, data)
+		} else {
+			writeScript(scriptPath("nerdctlPath", *nerdctlPath, style), "nerdctl", nerdctlTemplate, style, data)
+		}
+	}
+
+	if *makefileOutput != "" {
+		writeScript(*makefileOutput, "makefile", makefileTemplate, scriptStyles["bash"], data)
+	}
+	if *ansibleOutput != "" {
+		writeScript(*ansibleOutput, "ansible", ansibleTemplate, scriptStyles["bash"], data)
+	}
+	return output
+}
+
+// confirmPlans 打印待转换镜像、数量和通过 sizeOf 估算的总大小，并从 in 读取 y/N 确认，
+// 无法获取大小的镜像不计入总大小
+func confirmPlans(in io.Reader, out io.Writer, plans []mirrorOutput, sizeOf func(image string) (int64, error)) bool {
+	sizes := make([]int64, len(plans))
+	var wg sync.WaitGroup
+	for i, plan := range plans {
+		wg.Add(1)
+		go func(i int, image string) {
+			defer wg.Done()
+			size, err := sizeOf(image)
+			if err != nil {
+				size = -1
+			}
+			sizes[i] = size
+		}(i, plan.Pull)
+	}
+	wg.Wait()
+	var total int64
+	unknown := 0
+	for i, plan := range plans {
+		if sizes[i] < 0 {
+			unknown++
+			fmt.Fprintln(out, plan.Pull, "=>", plan.Target, "（大小未知）")
+			continue
+		}
+		total += sizes[i]
+		fmt.Fprintln(out, plan.Pull, "=>", plan.Target, units.BytesSize(float64(sizes[i])))
+	}
+	fmt.Fprintf(out, "共 %d 个镜像，估算总大小 %s", len(plans), units.BytesSize(float64(total)))
+	if unknown > 0 {
+		fmt.Fprintf(out, "（%d 个镜像无法获取大小，未计入）", unknown)
+	}
+	fmt.Fprint(out, "，是否继续？[y/N] ")
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// stageContext 为单个阶段创建 context，timeout 为 0 时不设超时
+func stageContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
+// pushedDigest 上传结果中没有 digest 时，从本地镜像的 RepoDigests 中查找目标仓库的 digest
+func pushedDigest(ctx context.Context, cli *client.Client, target string) string {
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, target)
+	if err != nil {
+		return ""
+	}
+	repo, _ := splitTag(target)
+	for _, repoDigest := range inspect.RepoDigests {
+		name, digest := splitDigest(repoDigest)
+		if name == repo {
+			return digest
+		}
+	}
+	return ""
+}
+
+// contains 判断 list 中是否包含 s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// retryDelay 第一次重试前等待的时间，之后每次递增
+var retryDelay = time.Second
+
+// withRetries 执行 fn，失败时最多重试 retries 次，每次重试前等待的时间递增，
+// ctx 取消、磁盘空间不足或目标 tag 不可变时不再重试
+func withRetries(ctx context.Context, retries int, name string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > retries || ctx.Err() != nil || isNoSpace(err) || isImmutableTag(err) {
+			return err
+		}
+		fmt.Println("第", attempt, "次重试", name, redact(err.Error()))
+		select {
+		case <-time.After(time.Duration(attempt) * retryDelay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// namespacePattern Docker Hub 命名空间的格式
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+// flushLog 恢复标准输出并等待 --log-file 写完，未设置时为空操作
+var flushLog = func() {}
+
+// exit 写完日志后退出
+func exit(code int) {
+	flushLog()
+	os.Exit(code)
 }
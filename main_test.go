@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestConfirmPlans(t *testing.T) {
+	plans := []mirrorOutput{
+		{Pull: "nginx:1.25", Target: "u/nginx:1.25"},
+		{Pull: "redis:7", Target: "u/redis:7"},
+		{Pull: "private/app:1", Target: "u/private.app:1"},
+	}
+	sizes := map[string]int64{"nginx:1.25": 1024, "redis:7": 2048}
+	sizeOf := func(image string) (int64, error) {
+		if size, ok := sizes[image]; ok {
+			return size, nil
+		}
+		return 0, errors.New("unauthorized")
+	}
+	tests := []struct {
+		answer string
+		want   bool
+	}{
+		{"y\n", true},
+		{"Yes\n", true},
+		{" y \n", true},
+		{"n\n", false},
+		{"no\n", false},
+		{"\n", false},
+		{"", false},
+		{"yep\n", false},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		got := confirmPlans(strings.NewReader(tt.answer), &out, plans, sizeOf)
+		if got != tt.want {
+			t.Errorf("confirmPlans(%q) = %v, want %v", tt.answer, got, tt.want)
+		}
+		for _, want := range []string{"共 3 个镜像", "估算总大小 3KiB", "1 个镜像无法获取大小", "private/app:1 => u/private.app:1 （大小未知）", "nginx:1.25 => u/nginx:1.25 1KiB"} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("confirmPlans(%q) output missing %q:\n%s", tt.answer, want, out.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docker/distribution/reference"
+)
+
+// mappingEntry 映射文件中的一条记录
+type mappingEntry struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Digest string `json:"digest,omitempty"`
+	PullMs int64  `json:"pull_ms"`
+	TagMs  int64  `json:"tag_ms"`
+	PushMs int64  `json:"push_ms"`
+}
+
+// writeMapping 将转换结果写入映射文件
+func writeMapping(file string, output []mirrorOutput) {
+	entries := make([]mappingEntry, 0, len(output))
+	for _, o := range output {
+		entries = append(entries, mappingEntry{
+			Source: o.Source,
+			Target: o.Target,
+			Digest: o.Digest,
+			PullMs: o.PullMs,
+			TagMs:  o.TagMs,
+			PushMs: o.PushMs,
+		})
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	err = os.WriteFile(file, data, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// digestMapEntry 源镜像 tag 与实际上传的 digest 的对应关系
+type digestMapEntry struct {
+	Source       string `json:"source"`
+	PushedDigest string `json:"pushed_digest"`
+}
+
+// writeDigestMap 将每个源镜像实际上传的 digest 写入文件，不知道 digest 的镜像不写入
+func writeDigestMap(file string, output []mirrorOutput) {
+	entries := make([]digestMapEntry, 0, len(output))
+	for _, o := range output {
+		if o.PushedDigest == "" {
+			continue
+		}
+		entries = append(entries, digestMapEntry{Source: o.Source, PushedDigest: o.PushedDigest})
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	err = os.WriteFile(file, data, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// readDigestMap 读取 writeDigestMap 生成的文件
+func readDigestMap(file string) []digestMapEntry {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+	var entries []digestMapEntry
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		panic(err)
+	}
+	return entries
+}
+
+// readMapping 读取之前生成的映射文件
+func readMapping(file string) []mappingEntry {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+	var entries []mappingEntry
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		panic(err)
+	}
+	return entries
+}
+
+// diffMapping 返回相对 base 新增或 digest 发生变化的结果
+func diffMapping(output []mirrorOutput, base []mappingEntry) []mirrorOutput {
+	digests := make(map[string]string, len(base))
+	for _, entry := range base {
+		digests[entry.Source] = entry.Digest
+	}
+	diff := make([]mirrorOutput, 0)
+	for _, o := range output {
+		digest, ok := digests[o.Source]
+		if ok && digest == o.Digest {
+			continue
+		}
+		diff = append(diff, o)
+	}
+	return diff
+}
+
+// repoDigest 从镜像的 RepoDigests 中取出与 source 同仓库的 digest
+func repoDigest(source string, repoDigests []string) string {
+	named, err := reference.ParseNormalizedNamed(source)
+	if err != nil {
+		return ""
+	}
+	if canonical, ok := named.(reference.Canonical); ok {
+		return canonical.Digest().String()
+	}
+	for _, repoDigest := range repoDigests {
+		index := strings.Index(repoDigest, "@")
+		if index == -1 {
+			continue
+		}
+		repo, err := reference.ParseNormalizedNamed(repoDigest[:index])
+		if err == nil && repo.Name() == named.Name() {
+			return repoDigest[index+1:]
+		}
+	}
+	return ""
+}
+
+// verifyDigest 校验拉取到的 digest 与 expected-digest 一致，expected 为空时不校验
+func verifyDigest(source, expected, digest string) error {
+	if expected != "" && digest != expected {
+		return fmt.Errorf("digest mismatch for %s: expected %s, got %s", source, expected, digest)
+	}
+	return nil
+}
+
+// listTargets 以表格或 JSON 格式输出源镜像与目标镜像的对应关系
+func listTargets(w io.Writer, format string, plans []mirrorOutput) {
+	switch format {
+	case "json":
+		// 只输出对应关系，不包含映射文件中的耗时
+		type targetEntry struct {
+			Source string `json:"source"`
+			Target string `json:"target"`
+		}
+		entries := make([]targetEntry, 0, len(plans))
+		for _, plan := range plans {
+			entries = append(entries, targetEntry{
+				Source: plan.Pull,
+				Target: plan.Target,
+			})
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(entries)
+		if err != nil {
+			panic(err)
+		}
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "SOURCE\tTARGET")
+		for _, plan := range plans {
+			fmt.Fprintf(tw, "%s\t%s\n", plan.Pull, plan.Target)
+		}
+		tw.Flush()
+	default:
+		panic("unknown list format: " + format)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRepoDigest(t *testing.T) {
+	const other = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	tests := []struct {
+		name        string
+		source      string
+		repoDigests []string
+		want        string
+	}{
+		{"tag source", "nginx:1.25", []string{"nginx@" + testDigest}, testDigest},
+		{"normalized repo", "nginx:1.25", []string{"docker.io/library/nginx@" + testDigest}, testDigest},
+		{"multi repo", "gcr.io/team/app:v1", []string{"user/gcr.io.team.app@" + other, "gcr.io/team/app@" + testDigest}, testDigest},
+		{"other repo only", "nginx:1.25", []string{"user/nginx@" + other}, ""},
+		{"no repo digests", "nginx:1.25", nil, ""},
+		{"malformed entry", "nginx:1.25", []string{"nginx", "nginx@" + testDigest}, testDigest},
+		{"digest source", "nginx@" + testDigest, []string{"nginx@" + other}, testDigest},
+		{"tag and digest source", "nginx:1.25@" + testDigest, nil, testDigest},
+		{"invalid source", "Invalid Name", []string{"nginx@" + testDigest}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := repoDigest(tt.source, tt.repoDigests); got != tt.want {
+				t.Errorf("repoDigest(%q, %q) = %q, want %q", tt.source, tt.repoDigests, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyDigest(t *testing.T) {
+	const other = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	tests := []struct {
+		expected    string
+		repoDigests []string
+		ok          bool
+	}{
+		{"", []string{"nginx@" + other}, true},
+		{testDigest, []string{"nginx@" + testDigest}, true},
+		{testDigest, []string{"user/nginx@" + other, "docker.io/library/nginx@" + testDigest}, true},
+		{testDigest, []string{"nginx@" + other}, false},
+		{testDigest, []string{"user/nginx@" + testDigest}, false},
+		{testDigest, nil, false},
+	}
+	for _, tt := range tests {
+		err := verifyDigest("nginx:1.25", tt.expected, repoDigest("nginx:1.25", tt.repoDigests))
+		if (err == nil) != tt.ok {
+			t.Errorf("verifyDigest(%q, %q) error = %v, want ok %v", tt.expected, tt.repoDigests, err, tt.ok)
+		}
+	}
+}
+
+func TestContentEntryJSON(t *testing.T) {
+	retries := 2
+	tests := []struct {
+		name  string
+		json  string
+		entry contentEntry
+	}{
+		{"string", `"nginx:1.25"`, contentEntry{Image: "nginx:1.25"}},
+		{"expected digest", `{"image":"nginx:1.25","expected-digest":"` + testDigest + `"}`, contentEntry{Image: "nginx:1.25", ExpectedDigest: testDigest}},
+		{"all fields", `{"image":"redis:7","expected-digest":"` + testDigest + `","retries":2,"timeout":"5m"}`, contentEntry{Image: "redis:7", ExpectedDigest: testDigest, Retries: &retries, Timeout: "5m"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entry contentEntry
+			if err := json.Unmarshal([]byte(tt.json), &entry); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(entry, tt.entry) {
+				t.Errorf("Unmarshal(%s) = %+v, want %+v", tt.json, entry, tt.entry)
+			}
+			data, err := json.Marshal(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.json {
+				t.Errorf("Marshal = %s, want %s", data, tt.json)
+			}
+		})
+	}
+	var entry contentEntry
+	if err := json.Unmarshal([]byte(`{"image":1}`), &entry); err == nil {
+		t.Error("Unmarshal accepted a non-string image")
+	}
+}
+
+func TestDiffMapping(t *testing.T) {
+	const changed = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	base := []mirrorOutput{
+		{Source: "nginx:1.25", Target: "u/nginx:1.25", Digest: testDigest},
+		{Source: "redis:7", Target: "u/redis:7", Digest: testDigest},
+		{Source: "removed:1", Target: "u/removed:1", Digest: testDigest},
+	}
+	file := filepath.Join(t.TempDir(), "mapping.json")
+	writeMapping(file, base)
+
+	output := []mirrorOutput{
+		{Source: "nginx:1.25", Target: "u/nginx:1.25", Digest: testDigest},
+		{Source: "redis:7", Target: "u/redis:7", Digest: changed},
+		{Source: "alpine:3.19", Target: "u/alpine:3.19", Digest: testDigest},
+	}
+	got := diffMapping(output, readMapping(file))
+	if want := []string{"redis:7", "alpine:3.19"}; !reflect.DeepEqual(sources(got), want) {
+		t.Errorf("diffMapping() = %v, want changed and new images %v", sources(got), want)
+	}
+	if got[0].Digest != changed {
+		t.Errorf("changed image digest = %s, want the new digest %s", got[0].Digest, changed)
+	}
+
+	if got := diffMapping(output, nil); len(got) != len(output) {
+		t.Errorf("diffMapping() without base = %v, want every image", sources(got))
+	}
+	if got := diffMapping(output[:1], readMapping(file)); len(got) != 0 {
+		t.Errorf("diffMapping() of unchanged images = %v, want none", sources(got))
+	}
+}
+
+func TestWriteDigestMap(t *testing.T) {
+	const pushed = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	output := []mirrorOutput{
+		{Source: "nginx:1.25", Target: "u/nginx:1.25", Digest: testDigest, PushedDigest: pushed},
+		// 目标 tag 不可变且无法查询到 digest 的镜像
+		{Source: "redis:7", Target: "u/redis:7", Digest: testDigest},
+		{Source: "alpine:3.19", Target: "u/alpine:3.19", PushedDigest: testDigest},
+	}
+	file := filepath.Join(t.TempDir(), "digests.json")
+	writeDigestMap(file, output)
+	want := []digestMapEntry{
+		{Source: "nginx:1.25", PushedDigest: pushed},
+		{Source: "alpine:3.19", PushedDigest: testDigest},
+	}
+	if got := readDigestMap(file); !reflect.DeepEqual(got, want) {
+		t.Errorf("readDigestMap() = %+v, want %+v", got, want)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), `"pushed_digest": ""`) {
+		t.Errorf("digest map has an empty pushed_digest:\n%s", data)
+	}
+
+	writeDigestMap(file, output[1:2])
+	if got := readDigestMap(file); len(got) != 0 {
+		t.Errorf("readDigestMap() = %+v, want no entries", got)
+	}
+}
+
+func TestListTargets(t *testing.T) {
+	plans := []mirrorOutput{
+		{Pull: "nginx:1.25", Source: "nginx:1.25", Target: "user/nginx:1.25", PullMs: 100},
+		{Pull: "gcr.io/google-containers/pause:3.9", Source: "gcr.io/google-containers/pause:3.9", Target: "user/gcr.io.google-containers.pause:3.9"},
+	}
+
+	var table bytes.Buffer
+	listTargets(&table, "table", plans)
+	wantTable := "SOURCE                              TARGET\n" +
+		"nginx:1.25                          user/nginx:1.25\n" +
+		"gcr.io/google-containers/pause:3.9  user/gcr.io.google-containers.pause:3.9\n"
+	if table.String() != wantTable {
+		t.Errorf("table output:\n%s\nwant:\n%s", table.String(), wantTable)
+	}
+
+	var out bytes.Buffer
+	listTargets(&out, "json", plans)
+	var entries []map[string]string
+	if err := json.Unmarshal(out.Bytes(), &entries); err != nil {
+		t.Fatalf("json output is not valid: %v\n%s", err, out.String())
+	}
+	want := []map[string]string{
+		{"source": "nginx:1.25", "target": "user/nginx:1.25"},
+		{"source": "gcr.io/google-containers/pause:3.9", "target": "user/gcr.io.google-containers.pause:3.9"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("json entries = %v, want only source and target %v", entries, want)
+	}
+
+	out.Reset()
+	listTargets(&out, "json", nil)
+	if strings.TrimSpace(out.String()) != "[]" {
+		t.Errorf("json output for no plans = %q, want []", out.String())
+	}
+
+	if message := mustPanic(t, func() { listTargets(&out, "yaml", plans) }); message != "unknown list format: yaml" {
+		t.Errorf("panic = %q, want an unknown format error", message)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+)
+
+// planOptions 生成转换计划的参数
+type planOptions struct {
+	// namespace 目标命名空间，见 targetNamespaceFor
+	namespace      string
+	customRegistry string
+	digestLength   int
+	preserveHost   bool
+	lowercase      bool
+	// retries 原始镜像未单独设置时的重试次数
+	retries int
+	// allowlist 镜像白名单，为 nil 时不限制
+	allowlist []string
+}
+
+// planSkip 无需转换的镜像及原因
+type planSkip struct {
+	plan   mirrorOutput
+	reason string
+}
+
+// planResult 原始镜像的转换计划
+type planResult struct {
+	plans []mirrorOutput
+	// skips 源镜像已经是目标镜像或已在自定义仓库中
+	skips []planSkip
+	// latest 未指定 tag 或使用 latest 的镜像
+	latest []string
+	// unapproved 不在白名单中的镜像，不生成计划
+	unapproved []string
+}
+
+// planMirrors 为原始镜像中的每一项计算源镜像、目标镜像及重试设置
+func planMirrors(entries []contentEntry, namer *targetNamer, opts planOptions) planResult {
+	result := planResult{plans: make([]mirrorOutput, 0, len(entries))}
+	for _, entry := range entries {
+		source := entry.Image
+		if source == "" {
+			continue
+		}
+		if opts.allowlist != nil && !allowed(source, opts.allowlist) {
+			result.unapproved = append(result.unapproved, source)
+			continue
+		}
+		if isLatest(source) {
+			result.latest = append(result.latest, source)
+		}
+		pull, restore := parseSource(source, opts.digestLength)
+		name := restore
+		if opts.preserveHost {
+			name = qualifiedName(restore)
+		}
+		target := namer.name(opts.namespace, name)
+		if opts.lowercase {
+			target = strings.ToLower(target)
+		}
+		plan := mirrorOutput{
+			Image:          source,
+			Pull:           pull,
+			Source:         restore,
+			Target:         target,
+			ExpectedDigest: entry.ExpectedDigest,
+		}
+		plan.Retries, plan.Timeout = entryOverrides(entry, opts.retries)
+		// 源镜像已经是目标镜像或已在自定义仓库中时，无需转换
+		if qualifiedName(restore) == qualifiedName(target) {
+			result.skips = append(result.skips, planSkip{plan, "目标镜像与源镜像相同"})
+			continue
+		}
+		if opts.customRegistry != "" && strings.HasPrefix(restore, opts.customRegistry+"/") {
+			result.skips = append(result.skips, planSkip{plan, "已在自定义仓库 " + opts.customRegistry + " 中"})
+			continue
+		}
+		result.plans = append(result.plans, plan)
+	}
+	return result
+}
+
+// targetNamespaceFor 返回目标命名空间：默认为登录的用户名，指定 targetNamespace 时使用该组织，
+// 登录仍使用 username
+func targetNamespaceFor(username, targetNamespace string) string {
+	if targetNamespace == "" {
+		return username
+	}
+	if !namespacePattern.MatchString(targetNamespace) {
+		panic("invalid --target-namespace: " + targetNamespace)
+	}
+	return targetNamespace
+}
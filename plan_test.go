@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanMirrors(t *testing.T) {
+	three := 3
+	entries := []contentEntry{
+		{Image: "nginx:1.25"},
+		{Image: ""},
+		{Image: "redis", Retries: &three, Timeout: "10m"},
+		{Image: "registry.example.com/team/app:1.0"},
+		{Image: "gcr.io/team/app:V1.0-RC"},
+	}
+	opts := planOptions{namespace: "user", customRegistry: "registry.example.com", digestLength: 12, lowercase: true, retries: 1}
+	result := planMirrors(entries, defaultNamer(), opts)
+
+	want := []mirrorOutput{
+		{Image: "nginx:1.25", Pull: "nginx:1.25", Source: "nginx:1.25", Target: "user/nginx:1.25", Retries: 1},
+		{Image: "redis", Pull: "redis", Source: "redis", Target: "user/redis", Retries: 3, Timeout: 10 * time.Minute},
+		{Image: "gcr.io/team/app:V1.0-RC", Pull: "gcr.io/team/app:V1.0-RC", Source: "gcr.io/team/app:V1.0-RC", Target: "user/gcr.io.team.app:v1.0-rc", Retries: 1},
+	}
+	if !reflect.DeepEqual(result.plans, want) {
+		t.Errorf("plans = %+v\nwant %+v", result.plans, want)
+	}
+	if !reflect.DeepEqual(result.latest, []string{"redis"}) {
+		t.Errorf("latest = %v, want [redis]", result.latest)
+	}
+	if len(result.skips) != 1 || result.skips[0].plan.Image != "registry.example.com/team/app:1.0" || !strings.Contains(result.skips[0].reason, "已在自定义仓库 registry.example.com 中") {
+		t.Errorf("skips = %+v, want the image already in the custom registry", result.skips)
+	}
+
+	// --dest-tag-lowercase=false 时保留大写
+	opts.lowercase = false
+	result = planMirrors(entries[4:], defaultNamer(), opts)
+	if len(result.plans) != 1 || result.plans[0].Target != "user/gcr.io.team.app:V1.0-RC" {
+		t.Errorf("plans without lowercasing = %+v, want the tag case kept", result.plans)
+	}
+}
+
+func TestPlanMirrorsSelfMirror(t *testing.T) {
+	// 目标模板只保留仓库名时，命名空间下的源镜像与目标相同
+	namer := newTargetNamer(`{{ .Namespace }}/{{ basename .Repo }}`, "{{ .Tag }}", nil, nil)
+	entries := []contentEntry{{Image: "user/app:1.0"}, {Image: "docker.io/user/tool:2"}, {Image: "other/app:1.0"}}
+	result := planMirrors(entries, namer, planOptions{namespace: "user", lowercase: true})
+	if got := sources(result.plans); !reflect.DeepEqual(got, []string{"other/app:1.0"}) {
+		t.Errorf("plans = %v, want only the image outside the namespace", got)
+	}
+	if len(result.skips) != 2 {
+		t.Fatalf("skips = %+v, want both self-mirrors", result.skips)
+	}
+	for _, skip := range result.skips {
+		if skip.reason != "目标镜像与源镜像相同" {
+			t.Errorf("skip %s reason = %q, want the self-mirror reason", skip.plan.Image, skip.reason)
+		}
+	}
+}
+
+func TestPlanMirrorsAllowlist(t *testing.T) {
+	entries := []contentEntry{{Image: "gcr.io/team/app:v1"}, {Image: "nginx:1.25"}, {Image: "gcr.io/other/app:v1"}}
+	result := planMirrors(entries, defaultNamer(), planOptions{namespace: "user", allowlist: []string{"gcr.io/team/*"}})
+	if got := sources(result.plans); !reflect.DeepEqual(got, []string{"gcr.io/team/app:v1"}) {
+		t.Errorf("plans = %v, want only the allowed image", got)
+	}
+	if want := []string{"nginx:1.25", "gcr.io/other/app:v1"}; !reflect.DeepEqual(result.unapproved, want) {
+		t.Errorf("unapproved = %v, want %v", result.unapproved, want)
+	}
+
+	// --preserve-host 保留 docker.io 等完整地址
+	result = planMirrors(entries[1:2], defaultNamer(), planOptions{namespace: "user", preserveHost: true})
+	if len(result.plans) != 1 || result.plans[0].Target != "user/docker.io.library.nginx:1.25" {
+		t.Errorf("plans with --preserve-host = %+v, want the full source name", result.plans)
+	}
+}
+
+func TestTargetNamespaceFor(t *testing.T) {
+	tests := []struct {
+		username, namespace, want string
+	}{
+		{"user", "", "user"},
+		{"user", "team-org", "team-org"},
+		{"user", "team.org_2", "team.org_2"},
+	}
+	for _, tt := range tests {
+		if got := targetNamespaceFor(tt.username, tt.namespace); got != tt.want {
+			t.Errorf("targetNamespaceFor(%q, %q) = %q, want %q", tt.username, tt.namespace, got, tt.want)
+		}
+	}
+	for _, invalid := range []string{"Team", "team/org", "-team", "team-", "team..org", "team org"} {
+		message := mustPanic(t, func() { targetNamespaceFor("user", invalid) })
+		if message != "invalid --target-namespace: "+invalid {
+			t.Errorf("targetNamespaceFor(%q) panic = %q, want invalid --target-namespace", invalid, message)
+		}
+	}
+}
+
+// TestTargetNamespaceAuth 推送到组织时目标镜像位于组织下，登录仍使用用户名
+func TestTargetNamespaceAuth(t *testing.T) {
+	credentials := map[string]registryCredential{}
+	dest := destCredential(credentials, "user", "user-pass")
+	namespace := targetNamespaceFor(dest.Username, "team-org")
+	result := planMirrors([]contentEntry{{Image: "nginx:1.25"}}, defaultNamer(), planOptions{namespace: namespace})
+	if len(result.plans) != 1 || result.plans[0].Target != "team-org/nginx:1.25" {
+		t.Errorf("plans = %+v, want the target under team-org", result.plans)
+	}
+	if auth := dest.authConfig(""); auth.Username != "user" || auth.Password != "user-pass" {
+		t.Errorf("login = %+v, want the user's own account", auth)
+	}
+	if credentials[dockerHubHost].Username != "user" {
+		t.Errorf("Docker Hub lookups use %q, want the user's account", credentials[dockerHubHost].Username)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/registry"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// distributionInspector 查询镜像的清单和平台，由 Docker 客户端实现
+type distributionInspector interface {
+	DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error)
+}
+
+// distributionCache 缓存 DistributionInspect 成功的结果，同一镜像在本次运行中只查询一次
+type distributionCache struct {
+	cli         distributionInspector
+	credentials map[string]registryCredential
+
+	mu      sync.Mutex
+	entries map[string]*cachedDistribution
+}
+
+type cachedDistribution struct {
+	once    sync.Once
+	inspect registry.DistributionInspect
+	err     error
+}
+
+func newDistributionCache(cli distributionInspector, credentials map[string]registryCredential) *distributionCache {
+	return &distributionCache{cli: cli, credentials: credentials, entries: make(map[string]*cachedDistribution)}
+}
+
+func (c *distributionCache) inspect(ctx context.Context, image string) (registry.DistributionInspect, error) {
+	c.mu.Lock()
+	cached, ok := c.entries[image]
+	if !ok {
+		cached = &cachedDistribution{}
+		c.entries[image] = cached
+	}
+	c.mu.Unlock()
+	cached.once.Do(func() {
+		cached.inspect, cached.err = c.cli.DistributionInspect(ctx, image, sourceAuth(c.credentials, image))
+	})
+	// 失败（包括调用方取消、仓库临时错误）的结果不缓存，之后的调用重新查询
+	if cached.err != nil {
+		c.mu.Lock()
+		if c.entries[image] == cached {
+			delete(c.entries, image)
+		}
+		c.mu.Unlock()
+	}
+	return cached.inspect, cached.err
+}
+
+// checkPlatform 检查源镜像是否提供指定平台，platform 格式为 os/arch[/variant]
+func checkPlatform(ctx context.Context, cache *distributionCache, source, platform string, includeAttestations bool) error {
+	inspect, err := cache.inspect(ctx, source)
+	if err != nil {
+		return err
+	}
+	available := make([]string, 0, len(inspect.Platforms))
+	for _, p := range inspect.Platforms {
+		if !includeAttestations && isAttestation(p) {
+			continue
+		}
+		if matchPlatform(p, platform) {
+			return nil
+		}
+		available = append(available, formatPlatform(p))
+	}
+	// 仓库未返回平台信息时无法判断，交由拉取处理
+	if len(available) == 0 {
+		return nil
+	}
+	return fmt.Errorf("platform %s not available for %s; available: %v", platform, source, available)
+}
+
+// availablePlatforms 返回源镜像清单列表中的平台，不是多平台镜像时返回空
+func availablePlatforms(ctx context.Context, cache *distributionCache, source string, includeAttestations bool) ([]string, error) {
+	inspect, err := cache.inspect(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	available := make([]string, 0, len(inspect.Platforms))
+	for _, p := range inspect.Platforms {
+		if includeAttestations || !isAttestation(p) {
+			available = append(available, formatPlatform(p))
+		}
+	}
+	return available, nil
+}
+
+// isNoMatchingManifest 判断是否为清单列表中没有匹配平台导致的拉取错误
+func isNoMatchingManifest(err error) bool {
+	return strings.Contains(err.Error(), "no matching manifest")
+}
+
+// isAttestation 判断是否为 buildkit 生成的 provenance/SBOM 等证明清单，其平台为 unknown/unknown
+func isAttestation(p v1.Platform) bool {
+	return p.OS == "unknown" && p.Architecture == "unknown"
+}
+
+// matchPlatform 判断 p 是否满足 os/arch[/variant]，未指定 variant 时不比较 variant
+func matchPlatform(p v1.Platform, platform string) bool {
+	parts := strings.SplitN(platform, "/", 3)
+	if len(parts) < 2 || p.OS != parts[0] || p.Architecture != parts[1] {
+		return false
+	}
+	return len(parts) == 2 || p.Variant == parts[2]
+}
+
+// formatPlatform 将平台格式化为 os/arch[/variant]
+func formatPlatform(p v1.Platform) string {
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// stubInspector 按镜像名返回预设的 DistributionInspect 结果
+type stubInspector struct {
+	mu       sync.Mutex
+	platform map[string][]v1.Platform
+	errs     map[string]error
+	calls    map[string]int
+	auths    map[string]string
+}
+
+func newStubInspector() *stubInspector {
+	return &stubInspector{platform: make(map[string][]v1.Platform), errs: make(map[string]error), calls: make(map[string]int), auths: make(map[string]string)}
+}
+
+func (s *stubInspector) DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[image]++
+	s.auths[image] = encodedRegistryAuth
+	if err := s.errs[image]; err != nil {
+		return registry.DistributionInspect{}, err
+	}
+	return registry.DistributionInspect{Platforms: s.platform[image]}, nil
+}
+
+// testPlatforms 多平台镜像的清单列表，包含 buildkit 的证明清单
+var testPlatforms = []v1.Platform{
+	{OS: "linux", Architecture: "amd64"},
+	{OS: "linux", Architecture: "arm64", Variant: "v8"},
+	{OS: "linux", Architecture: "arm", Variant: "v7"},
+	{OS: "unknown", Architecture: "unknown"},
+	{OS: "unknown", Architecture: "unknown"},
+}
+
+func TestCheckPlatform(t *testing.T) {
+	stub := newStubInspector()
+	stub.platform["multi:1"] = testPlatforms
+	stub.platform["single:1"] = []v1.Platform{{OS: "linux", Architecture: "amd64"}}
+	stub.platform["attestations-only:1"] = []v1.Platform{{OS: "unknown", Architecture: "unknown"}}
+	stub.errs["private:1"] = errors.New("unauthorized: authentication required")
+	cache := newDistributionCache(stub, nil)
+
+	tests := []struct {
+		source, platform    string
+		includeAttestations bool
+		// want 为空时应通过检查
+		want string
+	}{
+		{"multi:1", "linux/amd64", false, ""},
+		{"multi:1", "linux/arm64", false, ""},
+		{"multi:1", "linux/arm64/v8", false, ""},
+		{"multi:1", "linux/arm/v7", false, ""},
+		{"multi:1", "linux/arm/v6", false, "platform linux/arm/v6 not available for multi:1; available: [linux/amd64 linux/arm64/v8 linux/arm/v7]"},
+		{"multi:1", "windows/amd64", false, "available: [linux/amd64 linux/arm64/v8 linux/arm/v7]"},
+		// 证明清单不是可拉取的平台
+		{"multi:1", "unknown/unknown", false, "available: [linux/amd64 linux/arm64/v8 linux/arm/v7]"},
+		{"multi:1", "unknown/unknown", true, ""},
+		{"multi:1", "linux/s390x", true, "available: [linux/amd64 linux/arm64/v8 linux/arm/v7 unknown/unknown unknown/unknown]"},
+		{"single:1", "linux/amd64", false, ""},
+		{"single:1", "linux/arm64", false, "platform linux/arm64 not available for single:1; available: [linux/amd64]"},
+		// 仓库没有返回可判断的平台时交由拉取处理
+		{"unknown:1", "linux/arm64", false, ""},
+		{"attestations-only:1", "linux/arm64", false, ""},
+		{"private:1", "linux/amd64", false, "unauthorized: authentication required"},
+	}
+	for _, tt := range tests {
+		err := checkPlatform(context.Background(), cache, tt.source, tt.platform, tt.includeAttestations)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("checkPlatform(%s, %s, %v) = %v, want nil", tt.source, tt.platform, tt.includeAttestations, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("checkPlatform(%s, %s, %v) = %v, want %q", tt.source, tt.platform, tt.includeAttestations, err, tt.want)
+		}
+	}
+	if stub.calls["multi:1"] != 1 {
+		t.Errorf("multi:1 inspected %d times, want the cached result reused", stub.calls["multi:1"])
+	}
+}
+
+func TestAvailablePlatforms(t *testing.T) {
+	stub := newStubInspector()
+	stub.platform["multi:1"] = testPlatforms
+	stub.errs["missing:1"] = errors.New("manifest unknown")
+	cache := newDistributionCache(stub, nil)
+
+	// 拉取时 Docker 守护进程返回的错误
+	pullErr := errors.New("no matching manifest for linux/s390x in the manifest list entries")
+	if !isNoMatchingManifest(pullErr) {
+		t.Fatalf("isNoMatchingManifest(%v) = false", pullErr)
+	}
+	if isNoMatchingManifest(errors.New("manifest unknown")) {
+		t.Error("isNoMatchingManifest matched a missing manifest")
+	}
+
+	got, err := availablePlatforms(context.Background(), cache, "multi:1", false)
+	if want := []string{"linux/amd64", "linux/arm64/v8", "linux/arm/v7"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("availablePlatforms() = %v, %v, want %v", got, err, want)
+	}
+	got, err = availablePlatforms(context.Background(), cache, "multi:1", true)
+	if err != nil || len(got) != len(testPlatforms) {
+		t.Errorf("availablePlatforms() with attestations = %v, %v, want all %d platforms", got, err, len(testPlatforms))
+	}
+	if got, err := availablePlatforms(context.Background(), cache, "single:1", false); err != nil || len(got) != 0 {
+		t.Errorf("availablePlatforms() of a single-platform image = %v, %v, want none", got, err)
+	}
+	if _, err := availablePlatforms(context.Background(), cache, "missing:1", false); err == nil {
+		t.Error("availablePlatforms() ignored the inspect error")
+	}
+}
+
+func TestMatchPlatform(t *testing.T) {
+	tests := []struct {
+		p        v1.Platform
+		platform string
+		want     bool
+	}{
+		{v1.Platform{OS: "linux", Architecture: "amd64"}, "linux/amd64", true},
+		{v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, "linux/arm64", true},
+		{v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, "linux/arm64/v8", true},
+		{v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, "linux/arm/v6", false},
+		{v1.Platform{OS: "linux", Architecture: "arm"}, "linux/arm/v7", false},
+		{v1.Platform{OS: "windows", Architecture: "amd64"}, "linux/amd64", false},
+		{v1.Platform{OS: "linux", Architecture: "amd64"}, "linux", false},
+	}
+	for _, tt := range tests {
+		if got := matchPlatform(tt.p, tt.platform); got != tt.want {
+			t.Errorf("matchPlatform(%s, %s) = %v, want %v", formatPlatform(tt.p), tt.platform, got, tt.want)
+		}
+	}
+}
+
+func TestDistributionCache(t *testing.T) {
+	stub := newStubInspector()
+	stub.platform["ghcr.io/org/app:v1"] = testPlatforms
+	stub.errs["nginx:1.25"] = errors.New("toomanyrequests")
+	credentials := map[string]registryCredential{"ghcr.io": {Token: "gh-token"}}
+	cache := newDistributionCache(stub, credentials)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.inspect(context.Background(), "ghcr.io/org/app:v1")
+		}()
+	}
+	wg.Wait()
+	if stub.calls["ghcr.io/org/app:v1"] != 1 {
+		t.Errorf("inspected %d times, want once", stub.calls["ghcr.io/org/app:v1"])
+	}
+	if stub.auths["ghcr.io/org/app:v1"] != sourceAuth(credentials, "ghcr.io/org/app:v1") {
+		t.Error("inspect did not use the registry credential")
+	}
+
+	// 失败的结果不缓存
+	for i := 0; i < 2; i++ {
+		if _, err := cache.inspect(context.Background(), "nginx:1.25"); err == nil {
+			t.Fatal("inspect ignored the error")
+		}
+	}
+	delete(stub.errs, "nginx:1.25")
+	if _, err := cache.inspect(context.Background(), "nginx:1.25"); err != nil {
+		t.Fatal(err)
+	}
+	if stub.calls["nginx:1.25"] != 3 {
+		t.Errorf("nginx:1.25 inspected %d times, want a retry after each failure", stub.calls["nginx:1.25"])
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// preflight 检查 Docker 守护进程、目标仓库登录和各源仓库的连通性，全部通过时返回 true
+func preflight(cli *client.Client, authConfig types.AuthConfig, rc *registryClient, plans []mirrorOutput) bool {
+	ctx := context.Background()
+	ok := true
+	report := func(name string, err error) {
+		if err != nil {
+			ok = false
+			fmt.Println("[失败]", name+":", redact(err.Error()))
+			return
+		}
+		fmt.Println("[通过]", name)
+	}
+
+	_, err := cli.Ping(ctx)
+	report("Docker 守护进程", err)
+	if err == nil {
+		_, err = cli.RegistryLogin(ctx, authConfig)
+		report("登录 "+dockerHubHost, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, plan := range plans {
+		host := imageHost(plan.Pull)
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		report("访问 "+host, rc.ping(ctx, host))
+	}
+	return ok
+}
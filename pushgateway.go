@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// pushMetrics 将本次转换的汇总以 Prometheus 文本格式推送到 Pushgateway，
+// 同一 job 的指标会被覆盖
+func pushMetrics(gateway, job string, s summary) error {
+	var buf bytes.Buffer
+	metric := func(name, help, kind string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("hub_mirror_images", "Number of images by final status.", "gauge")
+	fmt.Fprintf(&buf, "hub_mirror_images{status=%q} %d\n", statusSuccess, s.Succeeded)
+	fmt.Fprintf(&buf, "hub_mirror_images{status=%q} %d\n", statusSkipped, s.Skipped)
+	fmt.Fprintf(&buf, "hub_mirror_images{status=%q} %d\n", statusFailed, s.Failed)
+	metric("hub_mirror_image_bytes", "Total size of successfully mirrored images.", "gauge")
+	fmt.Fprintf(&buf, "hub_mirror_image_bytes %d\n", s.TotalBytes)
+	metric("hub_mirror_pushed_bytes", "Bytes actually uploaded, excluding existing layers.", "gauge")
+	fmt.Fprintf(&buf, "hub_mirror_pushed_bytes %d\n", s.PushedBytes)
+	metric("hub_mirror_duration_seconds", "Duration of the run.", "gauge")
+	fmt.Fprintf(&buf, "hub_mirror_duration_seconds %g\n", float64(s.DurationMs)/1000)
+	metric("hub_mirror_last_run_timestamp_seconds", "Time the run finished.", "gauge")
+	fmt.Fprintf(&buf, "hub_mirror_last_run_timestamp_seconds %d\n", time.Now().Unix())
+
+	endpoint := strings.TrimSuffix(gateway, "/") + "/metrics/job/" + url.PathEscape(job)
+	req, err := http.NewRequest(http.MethodPut, endpoint, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("push metrics to %s: %s", endpoint, resp.Status)
+	}
+	return nil
+}
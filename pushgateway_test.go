@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPushMetrics(t *testing.T) {
+	var method, path, contentType, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, path, contentType, body = r.Method, r.URL.EscapedPath(), r.Header.Get("Content-Type"), string(data)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	s := summary{Total: 6, Succeeded: 3, Skipped: 2, Failed: 1, TotalBytes: 1024, PushedBytes: 512, DurationMs: 1500}
+	if err := pushMetrics(server.URL+"/", "nightly mirror", s); err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/nightly%20mirror" {
+		t.Errorf("request = %s %s, want PUT /metrics/job/nightly%%20mirror", method, path)
+	}
+	if !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+	for _, want := range []string{
+		"# TYPE hub_mirror_images gauge\n",
+		`hub_mirror_images{status="success"} 3` + "\n",
+		`hub_mirror_images{status="skipped"} 2` + "\n",
+		`hub_mirror_images{status="failed"} 1` + "\n",
+		"hub_mirror_image_bytes 1024\n",
+		"hub_mirror_pushed_bytes 512\n",
+		"hub_mirror_duration_seconds 1.5\n",
+		"hub_mirror_last_run_timestamp_seconds ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body does not contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestPushMetricsFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	tests := []struct{ name, gateway, want string }{
+		{"bad status", server.URL, "400 Bad Request"},
+		{"unreachable", "http://127.0.0.1:1", "connect"},
+		{"invalid url", "://gateway", "missing protocol scheme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := pushMetrics(tt.gateway, "hub-mirror", summary{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("pushMetrics error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
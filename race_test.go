@@ -0,0 +1,7 @@
+//go:build race
+// +build race
+
+package main
+
+// raceEnabled 启用 -race 时内存分配会成倍增加，分配量相关的测试据此跳过
+const raceEnabled = true
@@ -0,0 +1,23 @@
+package main
+
+import "strings"
+
+// secrets 需要在输出中隐藏的密码、token 和编码后的认证信息，在开始转换前设置
+var secrets []string
+
+// addSecret 记录需要隐藏的值，空值忽略
+func addSecret(values ...string) {
+	for _, value := range values {
+		if value != "" {
+			secrets = append(secrets, value)
+		}
+	}
+}
+
+// redact 将 s 中出现的认证信息替换为 ******
+func redact(s string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, "******")
+	}
+	return s
+}
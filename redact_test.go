@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withSecrets 在测试期间注册 values 为需要隐藏的值
+func withSecrets(t *testing.T, values ...string) {
+	old := secrets
+	secrets = append([]string(nil), secrets...)
+	addSecret(values...)
+	t.Cleanup(func() { secrets = old })
+}
+
+// assertRedacted 检查 s 中不包含任何 values
+func assertRedacted(t *testing.T, what, s string, values ...string) {
+	t.Helper()
+	for _, value := range values {
+		if strings.Contains(s, value) {
+			t.Errorf("%s leaks %q:\n%s", what, value, s)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	const password, token = "s3cr3t-password", "tok-abcdef123456"
+	auth := encodeAuth(registryCredential{Username: "user", Password: password}.authConfig("ghcr.io"))
+	withSecrets(t, password, token, auth, "")
+
+	message := fmt.Sprintf("pull ghcr.io/a/b: login user:%s failed (token %s, X-Registry-Auth: %s)", password, token, auth)
+	got := redact(message)
+	assertRedacted(t, "redact", got, password, token, auth)
+	if !strings.Contains(got, "pull ghcr.io/a/b: login user:******") {
+		t.Errorf("redact = %q, want the rest of the message kept", got)
+	}
+	if redact("") != "" {
+		t.Error("redact of an empty message is not empty")
+	}
+}
+
+// newEchoRegistry 启动一个要求认证并在错误状态中回显收到的密码或 token 的仓库
+func newEchoRegistry(t *testing.T, scheme string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		echo := ""
+		if _, pass, ok := r.BasicAuth(); ok {
+			echo = pass
+		} else if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			echo = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if echo == "" {
+			w.Header().Set("WWW-Authenticate", scheme+` realm="echo"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 500 invalid credential %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", echo)
+		rw.Flush()
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestRedactRegistryErrors(t *testing.T) {
+	const password, token = "s3cr3t-password", "tok-abcdef123456"
+	basicHost, tokenHost := newEchoRegistry(t, "Basic"), newEchoRegistry(t, "Bearer")
+	credentials := map[string]registryCredential{
+		basicHost: {Username: "user", Password: password},
+		tokenHost: {Token: token},
+	}
+	withSecrets(t, password, token, encodeAuth(credentials[basicHost].authConfig(basicHost)))
+	rc := newRegistryClient(credentials, []string{basicHost, tokenHost})
+	plans := []mirrorOutput{
+		{Pull: basicHost + "/library/app:1.0", Source: basicHost + "/library/app:1.0", Target: basicHost + "/user/app:1.0"},
+		{Pull: tokenHost + "/library/app:1.0", Source: tokenHost + "/library/app:1.0", Target: tokenHost + "/user/app:1.0"},
+	}
+
+	// 未经 redact 的错误确实包含回显的认证信息
+	_, err := rc.digest(context.Background(), plans[0].Pull)
+	if err == nil || !strings.Contains(err.Error(), password) {
+		t.Fatalf("digest error = %v, want the echoed password before redaction", err)
+	}
+
+	var out bytes.Buffer
+	if checkPlans(context.Background(), &out, rc, plans) {
+		t.Error("checkPlans succeeded against a registry that rejects every request")
+	}
+	assertRedacted(t, "checkPlans output", out.String(), password, token)
+	if !strings.Contains(out.String(), "******") {
+		t.Errorf("checkPlans output has no redacted error:\n%s", out.String())
+	}
+
+	entries := checkDrift(context.Background(), rc, plans, nil)
+	report := filepath.Join(t.TempDir(), "drift.json")
+	stdout := captureStdout(t, func() { writeDrift(report, entries) })
+	assertRedacted(t, "writeDrift output", stdout, password, token)
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRedacted(t, "drift report", string(data), password, token)
+}
+
+// captureStdout 返回执行 f 期间写入标准输出的内容
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- data
+	}()
+	f()
+	os.Stdout = stdout
+	w.Close()
+	return string(<-done)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+)
+
+// registryClient 直接访问镜像仓库 v2 API 的客户端，用于 Docker 守护进程不提供的查询
+type registryClient struct {
+	client      *http.Client
+	credentials map[string]registryCredential
+	// plainHTTP 使用明文 HTTP 访问的仓库地址
+	plainHTTP map[string]bool
+	// headers 附加到仓库请求的请求头，key 为仓库地址，空字符串表示所有仓库
+	headers map[string]http.Header
+
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+	// manifests 本次运行中已获取的 manifest，同一引用只请求一次
+	manifests map[string]*cachedManifest
+}
+
+// cachedToken 缓存的 Bearer Token，过期后重新获取
+type cachedToken struct {
+	token   string
+	expires time.Time
+}
+
+// cachedManifest 缓存的 manifest，once 保证并发请求同一引用时只获取一次
+type cachedManifest struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+func newRegistryClient(credentials map[string]registryCredential, plainHTTP []string) *registryClient {
+	c := &registryClient{
+		client:      &http.Client{Timeout: time.Minute},
+		credentials: credentials,
+		plainHTTP:   make(map[string]bool),
+		headers:     make(map[string]http.Header),
+		tokens:      make(map[string]cachedToken),
+		manifests:   make(map[string]*cachedManifest),
+	}
+	for _, host := range plainHTTP {
+		c.plainHTTP[normalizeHost(host)] = true
+	}
+	return c
+}
+
+// addHeaders 解析 [host=]Name: value 格式的请求头，指定 host 时只附加到该仓库的请求，
+// 请求头的值视为敏感信息，不出现在日志中
+func (c *registryClient) addHeaders(headers []string) {
+	for _, header := range headers {
+		host := ""
+		// 仓库地址本身可能带端口，因此以 = 前不含空白、= 后仍有 Name: value 判断是否指定了仓库
+		if eq := strings.Index(header, "="); eq != -1 && !strings.ContainsAny(header[:eq], " \t") && strings.Contains(header[eq+1:], ":") {
+			host, header = normalizeHost(header[:eq]), header[eq+1:]
+		}
+		name, value := parseHeader(header)
+		if c.headers[host] == nil {
+			c.headers[host] = make(http.Header)
+		}
+		c.headers[host].Add(name, value)
+		addSecret(value)
+	}
+}
+
+// setHeaders 将所有仓库和 host 对应的自定义请求头附加到请求
+func (c *registryClient) setHeaders(req *http.Request, host string) {
+	for _, key := range []string{"", host} {
+		for name, values := range c.headers[key] {
+			for _, value := range values {
+				req.Header.Add(name, value)
+			}
+		}
+	}
+}
+
+// endpoint 返回仓库 API 的地址，Docker Hub 的 API 地址与镜像名中的地址不同
+func (c *registryClient) endpoint(host string) string {
+	if host == dockerHubHost {
+		return "https://registry-1.docker.io"
+	}
+	if c.plainHTTP[host] {
+		return "http://" + host
+	}
+	return "https://" + host
+}
+
+// do 发送请求，处理 Bearer/Basic 认证，缓存的 token 被拒绝（如已过期）时重新获取一次，
+// 被限流（429）时按 Retry-After 等待后重试
+func (c *registryClient) do(ctx context.Context, method, host, rawURL string, header http.Header) (*http.Response, error) {
+	const maxAttempts = 5
+	authorization := ""
+	// cached 当前 authorization 是否来自缓存的 token
+	cached := false
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		c.setHeaders(req, host)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized && (authorization == "" || cached):
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			authorization, cached, err = c.authorize(ctx, host, challenge, cached)
+			if err != nil {
+				return nil, err
+			}
+			if authorization == "" {
+				return nil, fmt.Errorf("%s %s: unauthorized", method, rawURL)
+			}
+		case resp.StatusCode == http.StatusTooManyRequests && attempt < maxAttempts:
+			wait := time.Duration(attempt) * time.Second
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+			resp.Body.Close()
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		default:
+			return resp, nil
+		}
+	}
+}
+
+// authorize 根据 WWW-Authenticate 返回 Authorization 请求头及其是否来自缓存的 token，
+// 无法认证时返回空字符串；refresh 时丢弃缓存的 token 重新获取
+func (c *registryClient) authorize(ctx context.Context, host, challenge string, refresh bool) (string, bool, error) {
+	scheme, params := parseChallenge(challenge)
+	credential := c.credentials[host]
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if credential.Username == "" {
+			return "", false, nil
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credential.Username+":"+credential.Password)), false, nil
+	case "bearer":
+		if credential.Token != "" {
+			return "Bearer " + credential.Token, false, nil
+		}
+		key := host + "|" + params["scope"]
+		c.mu.Lock()
+		if refresh {
+			delete(c.tokens, key)
+		}
+		cached, ok := c.tokens[key]
+		c.mu.Unlock()
+		if ok && time.Now().Before(cached.expires) {
+			return "Bearer " + cached.token, true, nil
+		}
+		token, expiresIn, err := c.fetchToken(ctx, params, credential)
+		if err != nil {
+			return "", false, err
+		}
+		c.mu.Lock()
+		c.tokens[key] = cachedToken{token: token, expires: time.Now().Add(expiresIn)}
+		c.mu.Unlock()
+		return "Bearer " + token, false, nil
+	}
+	return "", false, nil
+}
+
+// fetchToken 从认证服务获取 Bearer Token 及其有效期，没有认证信息时匿名获取。
+// 认证服务未返回 expires_in 时按规范视为 60 秒，提前 10 秒视为过期
+func (c *registryClient) fetchToken(ctx context.Context, params map[string]string, credential registryCredential) (string, time.Duration, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", 0, err
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	if params["scope"] != "" {
+		query.Set("scope", params["scope"])
+	}
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	if credential.Username != "" {
+		req.SetBasicAuth(credential.Username, credential.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("fetch token from %s: %s", realm.Host, resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", 0, err
+	}
+	if body.ExpiresIn <= 0 {
+		body.ExpiresIn = 60
+	}
+	expiresIn := time.Duration(body.ExpiresIn)*time.Second - 10*time.Second
+	if body.Token != "" {
+		return body.Token, expiresIn, nil
+	}
+	return body.AccessToken, expiresIn, nil
+}
+
+// parseChallenge 解析 WWW-Authenticate，如 Bearer realm="...",service="...",scope="..."
+func parseChallenge(challenge string) (scheme string, params map[string]string) {
+	params = make(map[string]string)
+	challenge = strings.TrimSpace(challenge)
+	index := strings.IndexByte(challenge, ' ')
+	if index == -1 {
+		return challenge, params
+	}
+	scheme, rest := challenge[:index], challenge[index+1:]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.IndexByte(rest, '=')
+		if eq == -1 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end == -1 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.IndexByte(rest, ','); comma != -1 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
+
+// listTags 通过 tags/list API 列出仓库的所有 tag，处理 Link 分页
+func (c *registryClient) listTags(ctx context.Context, repo string) ([]string, error) {
+	named, err := reference.ParseNormalizedNamed(repo)
+	if err != nil {
+		return nil, err
+	}
+	host := reference.Domain(named)
+	endpoint := c.endpoint(host)
+	next := endpoint + "/v2/" + reference.Path(named) + "/tags/list?n=100"
+	tags := make([]string, 0)
+	for next != "" {
+		resp, err := c.do(ctx, http.MethodGet, host, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("list tags of %s: %s", repo, resp.Status)
+		}
+		var body struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, body.Tags...)
+		next = nextLink(endpoint, resp.Header.Get("Link"))
+	}
+	return tags, nil
+}
+
+// nextLink 解析分页的 Link 请求头，如 </v2/nginx/tags/list?last=1.25&n=100>; rel="next"
+func nextLink(endpoint, link string) string {
+	if !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	start, end := strings.IndexByte(link, '<'), strings.IndexByte(link, '>')
+	if start == -1 || end < start {
+		return ""
+	}
+	target := link[start+1 : end]
+	if strings.HasPrefix(target, "/") {
+		return endpoint + target
+	}
+	return target
+}
+
+// ping 检查仓库 API 是否可访问，返回 401 也视为可访问
+func (c *registryClient) ping(ctx context.Context, host string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.endpoint(host)+"/v2/", nil)
+	if err != nil {
+		return err
+	}
+	c.setHeaders(req, host)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
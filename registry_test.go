@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		challenge string
+		scheme    string
+		params    map[string]string
+	}{
+		{
+			`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"`,
+			"Bearer",
+			map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io", "scope": "repository:library/nginx:pull"},
+		},
+		{
+			`Bearer realm="https://ghcr.io/token", service="ghcr.io",scope="repository:a/b:pull,push"`,
+			"Bearer",
+			map[string]string{"realm": "https://ghcr.io/token", "service": "ghcr.io", "scope": "repository:a/b:pull,push"},
+		},
+		{`Basic realm="Registry Realm"`, "Basic", map[string]string{"realm": "Registry Realm"}},
+		{`Basic realm=registry,Charset=UTF-8`, "Basic", map[string]string{"realm": "registry", "charset": "UTF-8"}},
+		{`Bearer`, "Bearer", map[string]string{}},
+		{`  Bearer realm="unterminated`, "Bearer", map[string]string{"realm": "unterminated"}},
+		{``, "", map[string]string{}},
+	}
+	for _, tt := range tests {
+		scheme, params := parseChallenge(tt.challenge)
+		if scheme != tt.scheme || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("parseChallenge(%q) = %q, %v, want %q, %v", tt.challenge, scheme, params, tt.scheme, tt.params)
+		}
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	const endpoint = "https://registry.example.com"
+	tests := []struct{ link, want string }{
+		{`</v2/nginx/tags/list?last=1.25&n=100>; rel="next"`, endpoint + "/v2/nginx/tags/list?last=1.25&n=100"},
+		{`<https://other.example.com/v2/nginx/tags/list?last=a>; rel="next"`, "https://other.example.com/v2/nginx/tags/list?last=a"},
+		{`</v2/nginx/tags/list?last=1.25>; rel="prev"`, ""},
+		{`rel="next"`, ""},
+		{``, ""},
+	}
+	for _, tt := range tests {
+		if got := nextLink(endpoint, tt.link); got != tt.want {
+			t.Errorf("nextLink(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+// newTestRegistry 启动一个需要 Bearer Token 认证、tags/list 分两页返回的仓库，
+// tokens 记录获取 token 的次数
+func newTestRegistry(t *testing.T, tokens *int32) *httptest.Server {
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(tokens, 1)
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "secret" || r.URL.Query().Get("scope") != "repository:library/app:pull" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "t1"})
+	})
+	mux.HandleFunc("/v2/library/app/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t1" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:library/app:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("X-Test") != "registry-test-header" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		tags := []string{"1.0", "1.1"}
+		if r.URL.Query().Get("last") == "1.1" {
+			tags = []string{"2.0"}
+		} else {
+			w.Header().Set("Link", `</v2/library/app/tags/list?last=1.1&n=100>; rel="next"`)
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"tags": tags})
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestListTags(t *testing.T) {
+	var tokens int32
+	server := newTestRegistry(t, &tokens)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		name       string
+		credential registryCredential
+		want       []string
+		wantErr    bool
+	}{
+		{"basic auth token", registryCredential{Username: "user", Password: "secret"}, []string{"1.0", "1.1", "2.0"}, false},
+		{"static token", registryCredential{Token: "t1"}, []string{"1.0", "1.1", "2.0"}, false},
+		{"wrong password", registryCredential{Username: "user", Password: "wrong"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&tokens, 0)
+			rc := newRegistryClient(map[string]registryCredential{host: tt.credential}, []string{host})
+			rc.addHeaders([]string{host + "=X-Test: registry-test-header"})
+			got, err := rc.listTags(context.Background(), host+"/library/app")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("listTags error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listTags = %q, want %q", got, tt.want)
+			}
+			if tt.credential.Token == "" && !tt.wantErr && atomic.LoadInt32(&tokens) != 1 {
+				t.Errorf("fetched token %d times, want 1 for both pages", tokens)
+			}
+		})
+	}
+}
+
+func TestListTagsNotFound(t *testing.T) {
+	var tokens int32
+	server := newTestRegistry(t, &tokens)
+	host := strings.TrimPrefix(server.URL, "http://")
+	rc := newRegistryClient(nil, []string{host})
+	_, err := rc.listTags(context.Background(), host+"/library/missing")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("listTags error = %v, want 404", err)
+	}
+}
+
+func TestDoRetryAfter(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	rc := newRegistryClient(nil, []string{host})
+	resp, err := rc.do(context.Background(), http.MethodGet, host, server.URL+"/v2/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || requests != 3 {
+		t.Errorf("status %d after %d requests, want 200 after 3", resp.StatusCode, requests)
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	rc := newRegistryClient(nil, nil)
+	rc.addHeaders([]string{"X-All: all-hosts-value", "localhost:5000=X-Host: local-value", "index.docker.io=X-Hub: hub-value"})
+	tests := []struct {
+		host string
+		want http.Header
+	}{
+		{"localhost:5000", http.Header{"X-All": {"all-hosts-value"}, "X-Host": {"local-value"}}},
+		{dockerHubHost, http.Header{"X-All": {"all-hosts-value"}, "X-Hub": {"hub-value"}}},
+		{"ghcr.io", http.Header{"X-All": {"all-hosts-value"}}},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		rc.setHeaders(req, tt.host)
+		if !reflect.DeepEqual(req.Header, tt.want) {
+			t.Errorf("setHeaders(%q) = %v, want %v", tt.host, req.Header, tt.want)
+		}
+	}
+}
+
+// fakeRegistry 模拟镜像仓库的 manifest 和 blob API，auth 时要求 Bearer Token 认证，
+// 接受已签发且未被 revoke 的 token
+type fakeRegistry struct {
+	server *httptest.Server
+	host   string
+
+	mu sync.Mutex
+	// manifests key 为 repo:tag 或 repo@digest
+	manifests map[string][]byte
+	blobs     map[string][]byte
+	auth      bool
+	tokens    map[string]bool
+	expiresIn int
+	// issued 签发 token 的次数
+	issued int
+	// requests 收到的仓库 API 请求，如 HEAD /v2/library/app/manifests/1.0
+	requests []string
+	// noDigestHeader 不返回 Docker-Content-Digest
+	noDigestHeader bool
+}
+
+func newFakeRegistry(t testing.TB, auth bool) *fakeRegistry {
+	f := &fakeRegistry{manifests: make(map[string][]byte), blobs: make(map[string][]byte), auth: auth, tokens: make(map[string]bool), expiresIn: 300}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	f.host = strings.TrimPrefix(f.server.URL, "http://")
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// client 返回访问该仓库的 registryClient
+func (f *fakeRegistry) client() *registryClient {
+	return newRegistryClient(nil, []string{f.host})
+}
+
+// setManifest 设置 repo:tag 的 manifest，返回其 digest
+func (f *fakeRegistry) setManifest(repo, tag string, manifest interface{}) string {
+	data, _ := json.Marshal(manifest)
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.manifests[repo+":"+tag] = data
+	f.manifests[repo+"@"+digest] = data
+	return digest
+}
+
+// setBlob 保存 blob，返回其 digest
+func (f *fakeRegistry) setBlob(data []byte) string {
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blobs[digest] = data
+	return digest
+}
+
+// revoke 使已签发的 token 失效，模拟 token 过期
+func (f *fakeRegistry) revoke() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = make(map[string]bool)
+}
+
+// requestLog 返回收到的仓库 API 请求
+func (f *fakeRegistry) requestLog() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+func (f *fakeRegistry) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/token" {
+		f.issued++
+		token := fmt.Sprintf("token-%d", f.issued)
+		f.tokens[token] = true
+		json.NewEncoder(w).Encode(map[string]interface{}{"token": token, "expires_in": f.expiresIn})
+		return
+	}
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	if f.auth && !f.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, f.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	var data []byte
+	digest := ""
+	if index := strings.Index(path, "/manifests/"); index != -1 {
+		repo, ref := path[:index], path[index+len("/manifests/"):]
+		sep := ":"
+		if strings.HasPrefix(ref, "sha256:") {
+			sep = "@"
+		}
+		data = f.manifests[repo+sep+ref]
+		if data != nil {
+			var m struct {
+				MediaType string `json:"mediaType"`
+			}
+			json.Unmarshal(data, &m)
+			w.Header().Set("Content-Type", m.MediaType)
+		}
+	} else if index := strings.Index(path, "/blobs/"); index != -1 {
+		digest = path[index+len("/blobs/"):]
+		data = f.blobs[digest]
+	}
+	if data == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !f.noDigestHeader {
+		sum := sha256.Sum256(data)
+		w.Header().Set("Docker-Content-Digest", "sha256:"+hex.EncodeToString(sum[:]))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn int
+		want      int
+	}{
+		{"cached", 300, 1},
+		{"expired", 5, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeRegistry(t, true)
+			f.expiresIn = tt.expiresIn
+			f.setManifest("library/app", "1.0", testManifest("sha256:a1"))
+			rc := f.client()
+			for i := 0; i < 3; i++ {
+				if _, err := rc.digest(context.Background(), f.host+"/library/app:1.0"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if f.issued != tt.want {
+				t.Errorf("issued %d tokens, want %d", f.issued, tt.want)
+			}
+		})
+	}
+}
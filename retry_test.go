@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// withRetryDelay 测试期间缩短重试的等待时间
+func withRetryDelay(t *testing.T, d time.Duration) {
+	old := retryDelay
+	retryDelay = d
+	t.Cleanup(func() { retryDelay = old })
+}
+
+func TestWithRetries(t *testing.T) {
+	withRetryDelay(t, time.Millisecond)
+	const defaultRetries = 1
+	five := 5
+	zero := 0
+	tests := []struct {
+		name  string
+		entry contentEntry
+		// succeedAt 第几次调用成功，0 表示始终失败
+		succeedAt int
+		err       error
+		calls     int
+	}{
+		{"global retries", contentEntry{Image: "nginx:1.25"}, 0, errors.New("unexpected EOF"), 2},
+		{"per-image retries", contentEntry{Image: "nginx:1.25", Retries: &five}, 0, errors.New("unexpected EOF"), 6},
+		{"per-image zero", contentEntry{Image: "nginx:1.25", Retries: &zero}, 0, errors.New("unexpected EOF"), 1},
+		{"succeeds on retry", contentEntry{Image: "nginx:1.25", Retries: &five}, 3, errors.New("unexpected EOF"), 3},
+		{"no space", contentEntry{Image: "nginx:1.25", Retries: &five}, 0, syscall.ENOSPC, 1},
+		{"immutable", contentEntry{Image: "nginx:1.25", Retries: &five}, 0, errors.New("unknown: Failed to process request due to 'u/nginx:1.25' configured as immutable."), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retries, _ := entryOverrides(tt.entry, defaultRetries)
+			calls := 0
+			err := withRetries(context.Background(), retries, tt.entry.Image, func() error {
+				calls++
+				if calls == tt.succeedAt {
+					return nil
+				}
+				return tt.err
+			})
+			if calls != tt.calls {
+				t.Errorf("fn called %d times, want %d", calls, tt.calls)
+			}
+			if tt.succeedAt > 0 && err != nil {
+				t.Errorf("withRetries() = %v, want success", err)
+			}
+			if tt.succeedAt == 0 && err != tt.err {
+				t.Errorf("withRetries() = %v, want the last error %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestWithRetriesCancel(t *testing.T) {
+	withRetryDelay(t, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	done := make(chan error)
+	go func() {
+		done <- withRetries(ctx, 3, "nginx:1.25", func() error {
+			calls++
+			return errors.New("unexpected EOF")
+		})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil || calls != 1 {
+			t.Errorf("withRetries() = %v after %d calls, want the error after 1 call", err, calls)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("withRetries kept waiting after ctx was cancelled")
+	}
+
+	// ctx 已取消时不再重试
+	calls = 0
+	withRetries(ctx, 3, "nginx:1.25", func() error {
+		calls++
+		return errors.New("unexpected EOF")
+	})
+	if calls != 1 {
+		t.Errorf("fn called %d times with a cancelled ctx, want 1", calls)
+	}
+}
+
+func TestStageContext(t *testing.T) {
+	ctx, cancel := stageContext(context.Background(), 0)
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("stageContext(0) has a deadline")
+	}
+	cancel()
+	if ctx.Err() == nil {
+		t.Error("cancel did not cancel the stage context")
+	}
+
+	start := time.Now()
+	ctx, cancel = stageContext(context.Background(), time.Minute)
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || deadline.Before(start.Add(time.Minute)) || deadline.After(time.Now().Add(time.Minute)) {
+		t.Errorf("stageContext(1m) deadline = %v, %v, want a minute from now", deadline, ok)
+	}
+
+	// 单个镜像的 timeout 早于 --pull-timeout/--push-timeout 时，阶段在镜像的截止时间结束
+	image, cancelImage := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelImage()
+	imageDeadline, _ := image.Deadline()
+	ctx, cancel = stageContext(image, time.Hour)
+	defer cancel()
+	if deadline, _ := ctx.Deadline(); !deadline.Equal(imageDeadline) {
+		t.Errorf("stage deadline = %v, want the per-image deadline %v", deadline, imageDeadline)
+	}
+	select {
+	case <-ctx.Done():
+		if ctx.Err() != context.DeadlineExceeded {
+			t.Errorf("stage ctx error = %v, want DeadlineExceeded", ctx.Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stage ctx outlived the per-image timeout")
+	}
+
+	// 阶段超时早于镜像的截止时间
+	image, cancelImage2 := context.WithTimeout(context.Background(), time.Hour)
+	defer cancelImage2()
+	ctx, cancel = stageContext(image, 20*time.Millisecond)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		if image.Err() != nil {
+			t.Error("the stage timeout cancelled the image context")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stage timeout did not fire")
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// mirrorOutput 单个镜像的转换结果
+type mirrorOutput struct {
+	// Image 原始镜像
+	Image string
+	// Pull 拉取时使用的引用
+	Pull string
+	// Source 脚本中还原的镜像名
+	Source string
+	Target string
+	// Digest 源镜像的 digest
+	Digest string
+	// PushedDigest 上传到目标仓库后的 manifest digest
+	PushedDigest string
+	// ExpectedDigest 期望的源镜像 digest，为空时不校验
+	ExpectedDigest string
+	// Restore output.sh 中 docker tag 还原的镜像名
+	Restore string
+	// PullMs、TagMs、PushMs 各阶段耗时（毫秒）
+	PullMs int64
+	TagMs  int64
+	PushMs int64
+	// Retries 拉取和上传失败时的重试次数
+	Retries int
+	// Timeout 该镜像转换（含重试）的总超时时间，0 表示不限制总时间
+	Timeout time.Duration
+}
+
+// scriptData 脚本模板数据
+type scriptData struct {
+	Output         []mirrorOutput
+	CustomRegistry string
+	// Invoke 命令调用前缀，如 PowerShell 的 &
+	Invoke string
+}
+
+// scriptStyle 脚本风格，决定换行符和命令写法
+type scriptStyle struct {
+	// Ext 默认输出文件扩展名
+	Ext string
+	// Newline 换行符
+	Newline string
+	// Invoke 命令调用前缀
+	Invoke string
+}
+
+var scriptStyles = map[string]scriptStyle{
+	"bash":       {Ext: ".sh", Newline: "\n"},
+	"powershell": {Ext: ".ps1", Newline: "\r\n", Invoke: "& "},
+	"cmd":        {Ext: ".cmd", Newline: "\r\n"},
+}
+
+// 基础模板：docker pull 和 docker tag
+const pullTemplate = `{{- range .Output -}}
+
+{{ $.Invoke }}docker pull {{ .Target }}
+{{ $.Invoke }}docker tag {{ .Target }} {{ .Restore }}
+
+{{ end -}}`
+
+// 自定义仓库模板
+const customRegistryTemplate = `{{- range .Output -}}
+
+{{ $.Invoke }}docker tag {{ .Target }} {{ $.CustomRegistry }}/{{ .Source }}
+{{ $.Invoke }}docker push {{ $.CustomRegistry }}/{{ .Source }}
+
+{{ end -}}`
+
+// nerdctl 模板（使用自定义仓库）
+const nerdctlCustomTemplate = `{{- range .Output -}}
+
+{{ $.Invoke }}nerdctl -n k8s.io pull {{ $.CustomRegistry }}/{{ .Source }}
+{{ $.Invoke }}nerdctl -n k8s.io tag {{ $.CustomRegistry }}/{{ .Source }} {{ .Source }}
+
+{{ end -}}`
+
+// nerdctl 模板
+const nerdctlTemplate = `{{- range .Output -}}
+
+{{ $.Invoke }}nerdctl -n k8s.io pull {{ .Target }}
+{{ $.Invoke }}nerdctl -n k8s.io tag {{ .Target }} {{ .Source }}
+
+{{ end -}}`
+
+// Makefile 模板：每个镜像一个 phony 目标，all 依赖全部镜像
+const makefileTemplate = `{{- define "name" }}{{ .Source | replace "[^A-Za-z0-9_.-]+" "-" }}{{ end -}}
+.PHONY: all{{ range .Output }} {{ template "name" . }}{{ end }}
+
+all:{{ range .Output }} {{ template "name" . }}{{ end }}
+{{ range .Output }}
+{{ template "name" . }}:
+	docker pull {{ .Target }}
+	docker tag {{ .Target }} {{ .Restore }}
+{{- if $.CustomRegistry }}
+	docker tag {{ .Target }} {{ $.CustomRegistry }}/{{ .Source }}
+	docker push {{ $.CustomRegistry }}/{{ .Source }}
+{{- end }}
+{{ end -}}`
+
+// Ansible playbook 模板：使用 community.docker.docker_image 拉取、还原标签，
+// 设置自定义仓库时上传到 custom_registry，可通过 -e custom_registry=... 覆盖
+const ansibleTemplate = `- name: hub-mirror
+  hosts: all
+  gather_facts: false
+  vars:
+    custom_registry: "{{ .CustomRegistry }}"
+  tasks:
+{{- range .Output }}
+    - name: "pull {{ .Target }}"
+      community.docker.docker_image:
+        name: "{{ .Target }}"
+        source: pull
+    - name: "tag {{ .Target }} as {{ .Restore }}"
+      community.docker.docker_image:
+        name: "{{ .Target }}"
+        repository: "{{ .Restore }}"
+        source: local
+{{- if $.CustomRegistry }}
+    - name: "push {{ .Source }} to custom registry"
+      community.docker.docker_image:
+        name: "{{ .Target }}"
+        repository: "{{ "{{" }} custom_registry {{ "}}" }}/{{ .Source }}"
+        push: true
+        source: local
+{{- end }}
+{{- end }}
+`
+
+// templateFuncs 所有输出模板可用的函数
+var templateFuncs = template.FuncMap{
+	"lower":    strings.ToLower,
+	"upper":    strings.ToUpper,
+	"basename": path.Base,
+	// replace 正则替换，如 {{ .Source | replace "[/:]" "-" }}
+	"replace": func(pattern, repl, s string) (string, error) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", err
+		}
+		return re.ReplaceAllString(s, repl), nil
+	},
+	// trimTag 去除镜像名中的 tag 和 digest
+	"trimTag": func(s string) string {
+		if index := strings.Index(s, "@"); index != -1 {
+			s = s[:index]
+		}
+		repo, _ := splitTag(s)
+		return repo
+	},
+}
+
+// restoreNames 按 --output-restore-target 计算 output.sh 中还原的镜像名：
+// source 还原为源镜像，custom 还原为自定义仓库中的镜像，其它值作为模板渲染
+func restoreNames(output []mirrorOutput, mode, customRegistry string) {
+	var tmpl *template.Template
+	switch mode {
+	case "source":
+	case "custom":
+		if customRegistry == "" {
+			panic("custom-registry is required for --output-restore-target=custom")
+		}
+	default:
+		var err error
+		tmpl, err = template.New("restore").Funcs(templateFuncs).Parse(mode)
+		if err != nil {
+			panic(err)
+		}
+	}
+	for i := range output {
+		switch {
+		case mode == "source":
+			output[i].Restore = output[i].Source
+		case mode == "custom":
+			output[i].Restore = customRegistry + "/" + output[i].Source
+		default:
+			var buf bytes.Buffer
+			err := tmpl.Execute(&buf, struct {
+				mirrorOutput
+				CustomRegistry string
+			}{output[i], customRegistry})
+			if err != nil {
+				panic(err)
+			}
+			output[i].Restore = buf.String()
+		}
+	}
+}
+
+// scriptPath 未显式指定输出路径时，按脚本风格替换默认扩展名
+func scriptPath(flagName, file string, style scriptStyle) string {
+	if pflag.CommandLine.Changed(flagName) {
+		return file
+	}
+	return strings.TrimSuffix(file, filepath.Ext(file)) + style.Ext
+}
+
+// writeScript 渲染模板并按脚本风格写入文件，直接渲染到带缓冲的文件，不在内存中拼接完整内容
+func writeScript(file, name, text string, style scriptStyle, data scriptData) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		panic(err)
+	}
+	data.Invoke = style.Invoke
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	buf := bufio.NewWriter(f)
+	var w io.Writer = buf
+	if style.Newline != "\n" {
+		w = newlineWriter{w: buf, newline: []byte(style.Newline)}
+	}
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		panic(err)
+	}
+	err = buf.Flush()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// newlineWriter 写入时将 \n 替换为指定的换行符
+type newlineWriter struct {
+	w       io.Writer
+	newline []byte
+}
+
+func (nw newlineWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		index := bytes.IndexByte(p, '\n')
+		if index == -1 {
+			_, err := nw.w.Write(p)
+			return written + len(p), err
+		}
+		if _, err := nw.w.Write(p[:index]); err != nil {
+			return written, err
+		}
+		if _, err := nw.w.Write(nw.newline); err != nil {
+			return written + index, err
+		}
+		written += index + 1
+		p = p[index+1:]
+	}
+	return written, nil
+}
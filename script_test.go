@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+var update = flag.Bool("update", false, "重新生成 testdata 中的 golden 文件")
+
+// testOutput 测试用的转换结果
+func testOutput() []mirrorOutput {
+	return []mirrorOutput{
+		{Source: "nginx:1.25", Target: "user/nginx:1.25", Restore: "nginx:1.25"},
+		{Source: "gcr.io/google-containers/pause:3.9", Target: "user/gcr.io.google-containers.pause:3.9", Restore: "gcr.io/google-containers/pause:3.9"},
+	}
+}
+
+// checkGolden 比较 got 与 testdata/name 的内容，-update 时覆盖 golden 文件
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	file := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(file, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch, run go test -update to regenerate\ngot:\n%q\nwant:\n%q", name, got, want)
+	}
+}
+
+func TestWriteScriptGolden(t *testing.T) {
+	templates := []struct {
+		name, text     string
+		customRegistry string
+	}{
+		{"output", pullTemplate, ""},
+		{"cusreg", customRegistryTemplate, "registry.example.com"},
+	}
+	for styleName, style := range scriptStyles {
+		for _, tmpl := range templates {
+			name := tmpl.name + style.Ext
+			t.Run(name, func(t *testing.T) {
+				file := filepath.Join(t.TempDir(), name)
+				writeScript(file, tmpl.name, tmpl.text, style, scriptData{Output: testOutput(), CustomRegistry: tmpl.customRegistry})
+				got, err := os.ReadFile(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkGolden(t, name+".golden", got)
+				if styleName != "bash" && bytes.Contains(bytes.ReplaceAll(got, []byte("\r\n"), nil), []byte("\n")) {
+					t.Errorf("%s style contains bare LF", styleName)
+				}
+			})
+		}
+	}
+}
+
+func TestNewlineWriter(t *testing.T) {
+	tests := []struct {
+		writes []string
+		want   string
+	}{
+		{[]string{"a\nb\n"}, "a\r\nb\r\n"},
+		{[]string{"no newline"}, "no newline"},
+		{[]string{"a", "\n", "b\n\n"}, "a\r\nb\r\n\r\n"},
+		{[]string{""}, ""},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w := newlineWriter{w: &buf, newline: []byte("\r\n")}
+		for _, s := range tt.writes {
+			n, err := w.Write([]byte(s))
+			if err != nil || n != len(s) {
+				t.Fatalf("Write(%q) = %d, %v", s, n, err)
+			}
+		}
+		if buf.String() != tt.want {
+			t.Errorf("newlineWriter(%q) = %q, want %q", tt.writes, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	tests := []struct {
+		text, want string
+		wantErr    bool
+	}{
+		{`{{ lower "NGINX:Alpine" }}`, "nginx:alpine", false},
+		{`{{ upper "nginx" }}`, "NGINX", false},
+		{`{{ basename "gcr.io/google-containers/pause" }}`, "pause", false},
+		{`{{ "gcr.io/pause:3.9" | replace "[/:]" "-" }}`, "gcr.io-pause-3.9", false},
+		{`{{ "a.b.c" | replace "\\." "" }}`, "abc", false},
+		{`{{ "nginx" | replace "[" "-" }}`, "", true},
+		{`{{ trimTag "nginx:1.25" }}`, "nginx", false},
+		{`{{ trimTag "localhost:5000/app" }}`, "localhost:5000/app", false},
+		{`{{ trimTag "localhost:5000/app:v1@sha256:abc" }}`, "localhost:5000/app", false},
+		{`{{ trimTag "nginx@sha256:abc" }}`, "nginx", false},
+	}
+	for _, tt := range tests {
+		tmpl, err := template.New("test").Funcs(templateFuncs).Parse(tt.text)
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", tt.text, err)
+		}
+		var buf bytes.Buffer
+		err = tmpl.Execute(&buf, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Execute(%s) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && buf.String() != tt.want {
+			t.Errorf("Execute(%s) = %q, want %q", tt.text, buf.String(), tt.want)
+		}
+	}
+}
+
+// largeOutput 生成 n 个镜像的转换结果
+func largeOutput(n int) []mirrorOutput {
+	output := make([]mirrorOutput, n)
+	for i := range output {
+		source := fmt.Sprintf("gcr.io/project/image-%d:v1.2.3", i)
+		output[i] = mirrorOutput{Source: source, Target: fmt.Sprintf("user/gcr.io.project.image-%d:v1.2.3", i), Restore: source}
+	}
+	return output
+}
+
+// TestWriteScriptMemory 流式写入时分配的内存远小于脚本大小，不会在内存中拼出整个脚本
+func TestWriteScriptMemory(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are inflated by the race detector")
+	}
+	data := scriptData{Output: largeOutput(10000)}
+	for styleName, style := range scriptStyles {
+		file := filepath.Join(t.TempDir(), "output"+style.Ext)
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		writeScript(file, "output", pullTemplate, style, data)
+		runtime.ReadMemStats(&after)
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allocated := after.TotalAlloc - before.TotalAlloc
+		if allocated > uint64(info.Size())/2 {
+			t.Errorf("%s: allocated %d bytes for a %d byte script", styleName, allocated, info.Size())
+		}
+	}
+}
+
+func BenchmarkWriteScript(b *testing.B) {
+	data := scriptData{Output: largeOutput(10000)}
+	for styleName, style := range scriptStyles {
+		b.Run(styleName, func(b *testing.B) {
+			file := filepath.Join(b.TempDir(), "output"+style.Ext)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				writeScript(file, "output", pullTemplate, style, data)
+			}
+		})
+	}
+}
+
+func BenchmarkRenderScripts(b *testing.B) {
+	templates := []struct{ name, text string }{
+		{"output", pullTemplate},
+		{"cusreg", customRegistryTemplate},
+		{"makefile", makefileTemplate},
+		{"ansible", ansibleTemplate},
+	}
+	for _, size := range []int{10, 100, 1000, 10000} {
+		data := scriptData{Output: largeOutput(size), CustomRegistry: "registry.example.com"}
+		for _, tmpl := range templates {
+			b.Run(fmt.Sprintf("%s/%d", tmpl.name, size), func(b *testing.B) {
+				file := filepath.Join(b.TempDir(), tmpl.name)
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					writeScript(file, tmpl.name, tmpl.text, scriptStyles["bash"], data)
+				}
+			})
+		}
+	}
+}
+
+func TestRestoreNamesGolden(t *testing.T) {
+	tests := []struct {
+		name, mode string
+	}{
+		{"source", "source"},
+		{"custom", "custom"},
+		{"template", `{{ .CustomRegistry }}/mirror/{{ .Source | replace "[/:]" "-" }}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := testOutput()
+			for i := range output {
+				output[i].Restore = ""
+			}
+			restoreNames(output, tt.mode, "registry.example.com")
+			file := filepath.Join(t.TempDir(), "output.sh")
+			writeScript(file, "output", pullTemplate, scriptStyles["bash"], scriptData{Output: output})
+			got, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, "restore-"+tt.name+".sh.golden", got)
+		})
+	}
+
+	message := mustPanic(t, func() { restoreNames(testOutput(), "custom", "") })
+	if message != "custom-registry is required for --output-restore-target=custom" {
+		t.Errorf("panic = %q, want custom-registry required", message)
+	}
+	mustPanic(t, func() { restoreNames(testOutput(), "{{ .Source", "") })
+	mustPanic(t, func() { restoreNames(testOutput(), "{{ .Missing }}", "") })
+}
+
+func TestMakefileGolden(t *testing.T) {
+	for _, customRegistry := range []string{"", "registry.example.com"} {
+		name := "Makefile"
+		if customRegistry != "" {
+			name += ".custom"
+		}
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "Makefile")
+			writeScript(file, "makefile", makefileTemplate, scriptStyles["bash"], scriptData{Output: testOutput(), CustomRegistry: customRegistry})
+			got, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, name+".golden", got)
+
+			// make 要求目标下的命令以 tab 开头
+			recipe := false
+			for i, line := range strings.Split(string(got), "\n") {
+				switch {
+				case line == "":
+					recipe = false
+				case recipe:
+					if !strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "\t ") {
+						t.Errorf("line %d %q: recipe line is not tab-indented", i+1, line)
+					}
+				case strings.HasSuffix(line, ":") && !strings.HasPrefix(line, "\t"):
+					recipe = true
+				case strings.HasPrefix(line, "\t") || strings.HasPrefix(line, " "):
+					t.Errorf("line %d %q: indented line outside a recipe", i+1, line)
+				}
+			}
+
+			makePath, err := exec.LookPath("make")
+			if err != nil {
+				t.Skip("make not installed")
+			}
+			out, err := exec.Command(makePath, "-n", "-f", file, "all").CombinedOutput()
+			if err != nil {
+				t.Fatalf("make -n: %v\n%s", err, out)
+			}
+			if want := strings.Count(string(got), "\tdocker "); strings.Count(string(out), "docker ") != want {
+				t.Errorf("make -n printed %q, want %d docker commands", out, want)
+			}
+		})
+	}
+}
+
+func TestAnsibleGolden(t *testing.T) {
+	for _, customRegistry := range []string{"", "registry.example.com"} {
+		name := "playbook"
+		if customRegistry != "" {
+			name += ".custom"
+		}
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "playbook.yml")
+			writeScript(file, "ansible", ansibleTemplate, scriptStyles["bash"], scriptData{Output: testOutput(), CustomRegistry: customRegistry})
+			got, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, name+".yml.golden", got)
+
+			var playbook []struct {
+				Name  string            `yaml:"name"`
+				Hosts string            `yaml:"hosts"`
+				Vars  map[string]string `yaml:"vars"`
+				Tasks []struct {
+					Name  string `yaml:"name"`
+					Image struct {
+						Name       string `yaml:"name"`
+						Repository string `yaml:"repository"`
+						Source     string `yaml:"source"`
+						Push       bool   `yaml:"push"`
+					} `yaml:"community.docker.docker_image"`
+				} `yaml:"tasks"`
+			}
+			if err := yaml.Unmarshal(got, &playbook); err != nil {
+				t.Fatalf("playbook is not valid YAML: %v", err)
+			}
+			if len(playbook) != 1 || playbook[0].Hosts != "all" {
+				t.Fatalf("playbook = %+v, want one play for all hosts", playbook)
+			}
+			play := playbook[0]
+			if play.Vars["custom_registry"] != customRegistry {
+				t.Errorf("custom_registry = %q, want %q", play.Vars["custom_registry"], customRegistry)
+			}
+			perImage := 2
+			if customRegistry != "" {
+				perImage = 3
+			}
+			output := testOutput()
+			if len(play.Tasks) != perImage*len(output) {
+				t.Fatalf("playbook has %d tasks, want %d", len(play.Tasks), perImage*len(output))
+			}
+			for i, o := range output {
+				tasks := play.Tasks[i*perImage : (i+1)*perImage]
+				if tasks[0].Image.Name != o.Target || tasks[0].Image.Source != "pull" {
+					t.Errorf("pull task = %+v, want pull %s", tasks[0], o.Target)
+				}
+				if tasks[1].Image.Repository != o.Restore || tasks[1].Image.Source != "local" {
+					t.Errorf("tag task = %+v, want repository %s", tasks[1], o.Restore)
+				}
+				if customRegistry != "" {
+					if want := "{{ custom_registry }}/" + o.Source; tasks[2].Image.Repository != want || !tasks[2].Image.Push {
+						t.Errorf("push task = %+v, want push to %s", tasks[2], want)
+					}
+				}
+			}
+		})
+	}
+}
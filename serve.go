@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/pflag"
+)
+
+// 任务状态
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+)
+
+// job 通过 --serve 提交的一次转换
+type job struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Dir 保存该任务原始镜像、脚本和汇总文件的目录
+	Dir      string `json:"dir"`
+	ExitCode int    `json:"exit_code"`
+	// Summary 与 --summary-json 相同的汇总，任务结束后才有
+	Summary json.RawMessage `json:"summary,omitempty"`
+	// Script 生成的 output.sh 内容
+	Script string `json:"script,omitempty"`
+	Output string `json:"output,omitempty"`
+}
+
+// serveExcluded 由每个任务单独指定、不从服务进程继承的参数
+var serveExcluded = map[string]bool{
+	"serve": true, "serve-concurrency": true, "serve-dir": true, "password": true, "header": true,
+	"content": true, "contentFile": true, "format": true, "content-header": true, "retry-file": true,
+	"outputPath": true, "customRegistryPath": true, "nerdctlPath": true,
+	"summary-json": true, "failures-file": true, "log-file": true, "log-max-size": true,
+}
+
+// jobServer 接收原始镜像 JSON 并按顺序交给子进程执行转换，同时运行的任务数不超过 concurrency
+type jobServer struct {
+	dir   string
+	args  []string
+	style scriptStyle
+	sem   chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// serve 启动 HTTP 服务：POST /jobs 提交与 --content 相同的 JSON，返回任务 id；
+// GET /jobs/{id} 查询任务状态。每个任务以当前参数重新执行本程序完成转换
+func serve(addr, dir string, concurrency int, style scriptStyle) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	s := &jobServer{
+		dir:   dir,
+		args:  inheritedArgs(pflag.CommandLine, serveExcluded),
+		style: style,
+		sem:   make(chan struct{}, concurrency),
+		jobs:  make(map[string]*job),
+	}
+	fmt.Println("等待提交转换任务", addr)
+	return http.ListenAndServe(addr, s.handler())
+}
+
+// handler 返回任务提交和查询的路由
+func (s *jobServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", s.submit)
+	mux.HandleFunc("/jobs/", s.status)
+	return mux
+}
+
+// inheritedArgs 将当前进程显式设置的参数（excluded 除外）转换为子进程的命令行参数
+func inheritedArgs(flags *pflag.FlagSet, excluded map[string]bool) []string {
+	args := make([]string, 0)
+	flags.Visit(func(f *pflag.Flag) {
+		if excluded[f.Name] {
+			return
+		}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			for _, value := range slice.GetSlice() {
+				args = append(args, "--"+f.Name+"="+value)
+			}
+			return
+		}
+		args = append(args, "--"+f.Name+"="+f.Value.String())
+	})
+	return args
+}
+
+func (s *jobServer) submit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 10<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var content mirrorContent
+	err = json.Unmarshal(body, &content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := make([]byte, 8)
+	_, err = rand.Read(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	j := &job{ID: hex.EncodeToString(id), Status: jobQueued}
+	j.Dir = filepath.Join(s.dir, j.ID)
+	err = os.MkdirAll(j.Dir, 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(j.Dir, "content.json"), body, 0644)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.mu.Lock()
+	s.jobs[j.ID] = j
+	s.mu.Unlock()
+	go s.run(j)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"id": j.ID})
+}
+
+func (s *jobServer) status(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(j)
+}
+
+// run 等待空闲后以子进程执行任务，完成后记录退出码、汇总和脚本
+func (s *jobServer) run(j *job) {
+	s.sem <- struct{}{}
+	defer func() { <-s.sem }()
+	s.setStatus(j, jobRunning)
+
+	executable, err := os.Executable()
+	if err != nil {
+		s.finish(j, -1, err.Error())
+		return
+	}
+	outputPath := filepath.Join(j.Dir, "output"+s.style.Ext)
+	args := append([]string{
+		"--contentFile=" + filepath.Join(j.Dir, "content.json"),
+		"--format=json",
+		"--outputPath=" + outputPath,
+		"--customRegistryPath=" + filepath.Join(j.Dir, "cusreg"+s.style.Ext),
+		"--nerdctlPath=" + filepath.Join(j.Dir, "nerdctl"+s.style.Ext),
+		"--summary-json=" + filepath.Join(j.Dir, "summary.json"),
+		"--failures-file=" + filepath.Join(j.Dir, "failures.json"),
+	}, s.args...)
+	cmd := exec.Command(executable, args...)
+	cmd.Env = childEnv()
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err = cmd.Run()
+	exitCode := 0
+	if err != nil {
+		exitCode = -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		}
+	}
+
+	summary, _ := os.ReadFile(filepath.Join(j.Dir, "summary.json"))
+	script, _ := os.ReadFile(outputPath)
+	s.mu.Lock()
+	if json.Valid(summary) {
+		j.Summary = summary
+	}
+	j.Script = string(script)
+	s.mu.Unlock()
+	s.finish(j, exitCode, redact(output.String()))
+}
+
+// childEnv 返回子进程的环境变量：参数已全部通过命令行传递，因此去除 HUBMIRROR_ 变量，
+// 密码和 --header 通过环境变量传递，避免出现在进程列表中
+func childEnv() []string {
+	env := make([]string, 0, len(os.Environ()))
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, envPrefix) {
+			env = append(env, e)
+		}
+	}
+	if *password != "" {
+		env = append(env, envName("password")+"="+*password)
+	}
+	if len(*registryHeaders) > 0 {
+		env = append(env, envName("header")+"="+strings.Join(*registryHeaders, "\n"))
+	}
+	return env
+}
+
+func (s *jobServer) setStatus(j *job, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.Status = status
+}
+
+// finish 按退出码记录任务结果，0 为成功
+func (s *jobServer) finish(j *job, exitCode int, output string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.ExitCode = exitCode
+	j.Output = output
+	j.Status = statusSuccess
+	if exitCode != 0 {
+		j.Status = statusFailed
+	}
+	fmt.Println("任务", j.ID, j.Status)
+}
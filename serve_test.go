@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// testChildEnv 设置后测试程序作为任务子进程运行，不访问 Docker：
+// 按 --contentFile 写出脚本和汇总，镜像名包含 fail 时以退出码 1 结束
+const testChildEnv = "HUB_MIRROR_TEST_CHILD"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testChildEnv) != "" {
+		os.Exit(fakeChild(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// fakeChild 模拟一次转换，返回退出码
+func fakeChild(args []string) int {
+	values := make(map[string]string)
+	for _, arg := range args {
+		if index := strings.Index(arg, "="); index != -1 {
+			values[strings.TrimPrefix(arg[:index], "--")] = arg[index+1:]
+		}
+	}
+	content := readContentFile(values["contentFile"], values["format"], nil)
+	var script strings.Builder
+	s := summary{Total: len(content.Content)}
+	for _, entry := range content.Content {
+		if strings.Contains(entry.Image, "fail") {
+			s.Failed++
+			continue
+		}
+		s.Succeeded++
+		fmt.Fprintf(&script, "docker pull %s/%s\n", values["namespace"], entry.Image)
+	}
+	os.WriteFile(values["outputPath"], []byte(script.String()), 0644)
+	data, _ := json.Marshal(s)
+	os.WriteFile(values["summary-json"], data, 0644)
+	fmt.Println("converted", s.Succeeded, "images")
+	if s.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// testJobServer 启动使用测试程序作为子进程的任务服务
+func testJobServer(t *testing.T, concurrency int) *httptest.Server {
+	t.Setenv(testChildEnv, "1")
+	s := &jobServer{
+		dir:   t.TempDir(),
+		args:  []string{"--namespace=user"},
+		style: scriptStyles["bash"],
+		sem:   make(chan struct{}, concurrency),
+		jobs:  make(map[string]*job),
+	}
+	server := httptest.NewServer(s.handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// submitJob 提交任务并返回任务 id
+func submitJob(t *testing.T, server *httptest.Server, body string) string {
+	t.Helper()
+	resp, err := http.Post(server.URL+"/jobs", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST /jobs = %s", resp.Status)
+	}
+	var result struct{ ID string }
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.ID == "" {
+		t.Fatalf("POST /jobs returned no id: %v", err)
+	}
+	return result.ID
+}
+
+// waitJob 轮询任务状态直到结束
+func waitJob(t *testing.T, server *httptest.Server, id string) job {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(server.URL + "/jobs/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var j job
+		err = json.NewDecoder(resp.Body).Decode(&j)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Status != jobQueued && j.Status != jobRunning {
+			return j
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return job{}
+}
+
+func TestServeJobs(t *testing.T) {
+	server := testJobServer(t, 1)
+	tests := []struct {
+		name, body string
+		status     string
+		exitCode   int
+		script     string
+		summary    summary
+	}{
+		{"success", `{"hub-mirror":["nginx:1.25","redis:7"]}`, statusSuccess, 0, "docker pull user/nginx:1.25\ndocker pull user/redis:7\n", summary{Total: 2, Succeeded: 2}},
+		{"failure", `{"hub-mirror":["nginx:1.25","fail:1"]}`, statusFailed, 1, "docker pull user/nginx:1.25\n", summary{Total: 2, Succeeded: 1, Failed: 1}},
+	}
+	ids := make([]string, len(tests))
+	for i, tt := range tests {
+		ids[i] = submitJob(t, server, tt.body)
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := waitJob(t, server, ids[i])
+			if j.Status != tt.status || j.ExitCode != tt.exitCode {
+				t.Errorf("job = %s (exit %d), want %s (exit %d)\n%s", j.Status, j.ExitCode, tt.status, tt.exitCode, j.Output)
+			}
+			if j.Script != tt.script {
+				t.Errorf("script = %q, want %q", j.Script, tt.script)
+			}
+			var s summary
+			if err := json.Unmarshal(j.Summary, &s); err != nil || s != tt.summary {
+				t.Errorf("summary = %s, want %+v", j.Summary, tt.summary)
+			}
+			if !strings.Contains(j.Output, "converted") {
+				t.Errorf("output = %q, want the child output", j.Output)
+			}
+		})
+	}
+}
+
+func TestServeErrors(t *testing.T) {
+	server := testJobServer(t, 1)
+	tests := []struct {
+		name, method, path, body string
+		want                     int
+	}{
+		{"get jobs", http.MethodGet, "/jobs", "", http.StatusMethodNotAllowed},
+		{"invalid json", http.MethodPost, "/jobs", `{"hub-mirror":`, http.StatusBadRequest},
+		{"unknown job", http.MethodGet, "/jobs/missing", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.body))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestInheritedArgs(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("namespace", "", "")
+	flags.String("password", "", "")
+	flags.StringArray("tag-regex-replace", nil, "")
+	flags.StringArray("header", nil, "")
+	flags.Int("retries", 3, "")
+	err := flags.Parse([]string{"--namespace=user", "--password=secret", "--tag-regex-replace=a=>b", "--tag-regex-replace=c=>d", "--header=X-A: 1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := inheritedArgs(flags, serveExcluded)
+	want := []string{"--namespace=user", "--tag-regex-replace=a=>b", "--tag-regex-replace=c=>d"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("inheritedArgs = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// manifestAccept 获取 manifest 时接受的类型，包括多平台清单和单平台清单
+var manifestAccept = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	v1.MediaTypeImageIndex,
+	"application/vnd.docker.distribution.manifest.v2+json",
+	v1.MediaTypeImageManifest,
+}
+
+// imageManifest 单平台镜像的 manifest 或多平台镜像的清单列表
+type imageManifest struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []v1.Descriptor `json:"manifests"`
+	Config    v1.Descriptor   `json:"config"`
+	Layers    []v1.Descriptor `json:"layers"`
+}
+
+// imageSize 通过 manifest 计算镜像压缩后的大小（config 和所有层），多平台镜像按 platform 选择
+func (c *registryClient) imageSize(ctx context.Context, image, platform string) (int64, error) {
+	_, _, manifest, err := c.platformManifest(ctx, image, platform)
+	if err != nil {
+		return 0, err
+	}
+	size := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return size, nil
+}
+
+// platformManifest 返回镜像所在的仓库地址、仓库名和单平台 manifest，多平台镜像按 platform 选择
+func (c *registryClient) platformManifest(ctx context.Context, image, platform string) (string, string, imageManifest, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", "", imageManifest{}, err
+	}
+	ref := "latest"
+	if canonical, ok := named.(reference.Canonical); ok {
+		ref = canonical.Digest().String()
+	} else if tagged, ok := named.(reference.Tagged); ok {
+		ref = tagged.Tag()
+	}
+	host, repo := reference.Domain(named), reference.Path(named)
+	for {
+		var manifest imageManifest
+		err = c.getManifest(ctx, host, repo, ref, &manifest)
+		if err != nil {
+			return "", "", imageManifest{}, err
+		}
+		if len(manifest.Manifests) == 0 {
+			return host, repo, manifest, nil
+		}
+		ref = ""
+		for _, m := range manifest.Manifests {
+			if m.Platform != nil && matchPlatform(*m.Platform, platform) {
+				ref = m.Digest.String()
+				break
+			}
+		}
+		if ref == "" {
+			return "", "", imageManifest{}, fmt.Errorf("platform %s not available for %s", platform, image)
+		}
+	}
+}
+
+// getManifest 获取 manifest 并解析到 v，同一引用成功获取的结果在本次运行中缓存，
+// 失败（包括调用方取消）时丢弃，之后的调用重新获取
+func (c *registryClient) getManifest(ctx context.Context, host, repo, ref string, v interface{}) error {
+	key := host + "/" + repo + "@" + ref
+	c.mu.Lock()
+	cached, ok := c.manifests[key]
+	if !ok {
+		cached = &cachedManifest{}
+		c.manifests[key] = cached
+	}
+	c.mu.Unlock()
+	cached.once.Do(func() {
+		cached.data, cached.err = c.fetchManifest(ctx, host, repo, ref)
+	})
+	if cached.err != nil {
+		c.mu.Lock()
+		if c.manifests[key] == cached {
+			delete(c.manifests, key)
+		}
+		c.mu.Unlock()
+		return cached.err
+	}
+	return json.Unmarshal(cached.data, v)
+}
+
+// fetchManifest 请求 manifest 的原始内容
+func (c *registryClient) fetchManifest(ctx context.Context, host, repo, ref string) ([]byte, error) {
+	header := http.Header{"Accept": {strings.Join(manifestAccept, ", ")}}
+	resp, err := c.do(ctx, http.MethodGet, host, c.endpoint(host)+"/v2/"+repo+"/manifests/"+ref, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get manifest %s:%s: %s", repo, ref, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
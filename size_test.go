@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// countRequests 返回 requests 中等于 request 的数量
+func countRequests(requests []string, request string) int {
+	n := 0
+	for _, r := range requests {
+		if r == request {
+			n++
+		}
+	}
+	return n
+}
+
+func TestImageSize(t *testing.T) {
+	f := newFakeRegistry(t, false)
+	amd64 := f.setManifest("library/app", "amd64", map[string]interface{}{
+		"mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+		"config":    map[string]interface{}{"digest": "sha256:c1", "size": 100},
+		"layers":    []interface{}{map[string]interface{}{"digest": "sha256:l1", "size": 1000}, map[string]interface{}{"digest": "sha256:l2", "size": 24}},
+	})
+	f.setManifest("library/app", "1.0", map[string]interface{}{
+		"mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+		"manifests": []interface{}{
+			map[string]interface{}{"digest": amd64, "size": 1, "platform": map[string]string{"os": "linux", "architecture": "amd64"}},
+		},
+	})
+	rc := f.client()
+	tests := []struct {
+		image, platform string
+		want            int64
+		wantErr         bool
+	}{
+		{f.host + "/library/app:amd64", "", 1124, false},
+		{f.host + "/library/app:1.0", "linux/amd64", 1124, false},
+		{f.host + "/library/app:1.0", "linux/arm64", 0, true},
+		{f.host + "/library/app:missing", "linux/amd64", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := rc.imageSize(context.Background(), tt.image, tt.platform)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("imageSize(%s, %s) = %d, %v, want %d", tt.image, tt.platform, got, err, tt.want)
+		}
+	}
+}
+
+func TestGetManifestCache(t *testing.T) {
+	f := newFakeRegistry(t, false)
+	f.setManifest("library/app", "1.0", testManifest("sha256:a1"))
+	rc := f.client()
+	image := f.host + "/library/app:1.0"
+
+	// 并发和重复查询同一镜像只请求一次 manifest
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := rc.imageSize(context.Background(), image, ""); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := rc.imageSize(context.Background(), image, ""); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRequests(f.requestLog(), "GET /v2/library/app/manifests/1.0"); n != 1 {
+		t.Errorf("fetched the manifest %d times, want 1", n)
+	}
+}
+
+func TestGetManifestCacheErrors(t *testing.T) {
+	f := newFakeRegistry(t, false)
+	rc := f.client()
+	image := f.host + "/library/app:1.0"
+
+	// 调用方取消的查询不影响之后的调用
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := rc.imageSize(ctx, image, ""); err == nil {
+		t.Fatal("imageSize with a cancelled context succeeded")
+	}
+	// manifest 尚不存在时失败，之后出现时重新获取
+	if _, err := rc.imageSize(context.Background(), image, ""); err == nil {
+		t.Fatal("imageSize of a missing manifest succeeded")
+	}
+	f.setManifest("library/app", "1.0", testManifest("sha256:a1"))
+	if size, err := rc.imageSize(context.Background(), image, ""); err != nil || size != 100 {
+		t.Errorf("imageSize after the manifest appeared = %d, %v, want 100", size, err)
+	}
+	if n := countRequests(f.requestLog(), "GET /v2/library/app/manifests/1.0"); n != 2 {
+		t.Errorf("fetched the manifest %d times, want 2", n)
+	}
+}
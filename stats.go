@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+// progressStats 某一时刻的转换进度
+type progressStats struct {
+	Done     int
+	Total    int
+	InFlight int64
+	// Bytes 已上传的字节数
+	Bytes int64
+}
+
+// startStats 每隔 interval 打印一行进度，适合输出到日志文件而非终端的长时间转换。
+// 返回的 stop 停止打印并等待打印协程退出
+func startStats(interval time.Duration, current func() progressStats) (stop func()) {
+	ticker := time.NewTicker(interval)
+	stopEmit := emitStats(ticker.C, os.Stdout, current)
+	return func() {
+		ticker.Stop()
+		stopEmit()
+	}
+}
+
+// emitStats 每次 ticks 触发时向 w 打印一行进度，返回的 stop 停止打印并等待打印协程退出
+func emitStats(ticks <-chan time.Time, w io.Writer, current func() progressStats) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case <-ticks:
+				s := current()
+				fmt.Fprintf(w, "进度：%d/%d 完成，已上传 %s，%d 个转换中\n", s.Done, s.Total, units.BytesSize(float64(s.Bytes)), s.InFlight)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmitStats(t *testing.T) {
+	ticks := make(chan time.Time)
+	var out bytes.Buffer
+	calls := 0
+	stop := emitStats(ticks, &out, func() progressStats {
+		calls++
+		return progressStats{Done: calls, Total: 3, InFlight: int64(3 - calls), Bytes: int64(calls) * 1024 * 1024}
+	})
+	for i := 0; i < 3; i++ {
+		ticks <- time.Now()
+	}
+	stop()
+
+	want := []string{
+		"进度：1/3 完成，已上传 1MiB，2 个转换中",
+		"进度：2/3 完成，已上传 2MiB，1 个转换中",
+		"进度：3/3 完成，已上传 3MiB，0 个转换中",
+	}
+	if got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("emitStats printed:\n%s\nwant one line per tick:\n%s", out.String(), strings.Join(want, "\n"))
+	}
+
+	// stop 返回后不再接收 tick
+	select {
+	case ticks <- time.Now():
+		t.Error("emitStats received a tick after stop")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if calls != 3 {
+		t.Errorf("current called %d times, want 3", calls)
+	}
+}
+
+func TestStartStatsStop(t *testing.T) {
+	before := runtime.NumGoroutine()
+	out := captureStdout(t, func() {
+		stop := startStats(time.Millisecond, func() progressStats { return progressStats{} })
+		time.Sleep(20 * time.Millisecond)
+		stop()
+	})
+	if !strings.Contains(out, "进度：0/0 完成") {
+		t.Errorf("startStats printed %q, want progress lines", out)
+	}
+	if n := waitGoroutines(before); n > before {
+		t.Errorf("goroutines = %d after stop, want %d", n, before)
+	}
+}
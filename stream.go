@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// copyStream 将 Docker 返回的 JSON 消息流逐行输出到 w，每条消息回调 observe（可为 nil），
+// 流中包含错误消息时返回该错误
+func copyStream(w io.Writer, r io.Reader, observe func(*jsonmessage.JSONMessage)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		w.Write(line)
+		w.Write([]byte{'\n'})
+		var msg jsonmessage.JSONMessage
+		if json.Unmarshal(line, &msg) != nil {
+			continue
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if observe != nil {
+			observe(&msg)
+		}
+	}
+	return scanner.Err()
+}
+
+// pushCounter 根据上传进度统计实际上传的字节数，仓库中已存在的层不计入，
+// 同时记录上传结果中的 manifest digest
+type pushCounter struct {
+	totals map[string]int64
+	pushed map[string]bool
+	// digest 上传完成后 aux 消息中的 digest
+	digest string
+}
+
+func newPushCounter() *pushCounter {
+	return &pushCounter{
+		totals: make(map[string]int64),
+		pushed: make(map[string]bool),
+	}
+}
+
+func (c *pushCounter) observe(msg *jsonmessage.JSONMessage) {
+	if msg.Aux != nil {
+		var result types.PushResult
+		if json.Unmarshal(*msg.Aux, &result) == nil && result.Digest != "" {
+			c.digest = result.Digest
+		}
+	}
+	if msg.ID == "" {
+		return
+	}
+	if msg.Progress != nil && msg.Progress.Total > c.totals[msg.ID] {
+		c.totals[msg.ID] = msg.Progress.Total
+	}
+	if msg.Status == "Pushed" {
+		c.pushed[msg.ID] = true
+	}
+}
+
+// bytes 返回已上传完成的层的总字节数
+func (c *pushCounter) bytes() int64 {
+	var total int64
+	for id := range c.pushed {
+		total += c.totals[id]
+	}
+	return total
+}
+
+// immutableTagMessages 目标仓库因不可变 tag 规则拒绝上传时的错误信息，只匹配规则本身的描述，
+// 避免仓库名中含有 immutable 的无权限等错误被误判
+var immutableTagMessages = []string{
+	// Harbor：Failed to process request due to '...' configured as immutable.
+	"configured as immutable",
+	// Amazon ECR：... cannot be overwritten because the repository is immutable.
+	"because the repository is immutable",
+}
+
+// isImmutableTag 判断是否为目标仓库的不可变 tag 规则拒绝上传，与无权限等其它拒绝区分
+func isImmutableTag(err error) bool {
+	message := strings.ToLower(err.Error())
+	for _, s := range immutableTagMessages {
+		if strings.Contains(message, s) {
+			return true
+		}
+	}
+	return false
+}
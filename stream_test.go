@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+func TestPushCounter(t *testing.T) {
+	const digest = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	stream := strings.Join([]string{
+		`{"status":"The push refers to repository [docker.io/user/app]"}`,
+		`{"status":"Preparing","progressDetail":{},"id":"layer-a"}`,
+		`{"status":"Preparing","progressDetail":{},"id":"layer-b"}`,
+		`{"status":"Preparing","progressDetail":{},"id":"layer-c"}`,
+		`{"status":"Pushing","progressDetail":{"current":512,"total":2048},"progress":"[=====>      ]","id":"layer-a"}`,
+		`{"status":"Pushing","progressDetail":{"current":1024,"total":4096},"id":"layer-c"}`,
+		`{"status":"Layer already exists","progressDetail":{},"id":"layer-b"}`,
+		`{"status":"Pushing","progressDetail":{"current":2048,"total":2048},"id":"layer-a"}`,
+		`{"status":"Pushed","progressDetail":{},"id":"layer-a"}`,
+		// 上传中途发现仓库中已存在，不计入已上传的字节数
+		`{"status":"Layer already exists","progressDetail":{},"id":"layer-c"}`,
+		`not json`,
+		`{"status":"1.0: digest: ` + digest + ` size: 528"}`,
+		`{"progressDetail":{},"aux":{"Tag":"1.0","Digest":"` + digest + `","Size":528}}`,
+	}, "\n") + "\n"
+
+	var out bytes.Buffer
+	counter := newPushCounter()
+	if err := copyStream(&out, strings.NewReader(stream), counter.observe); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != stream {
+		t.Errorf("copyStream output = %q, want the stream unchanged", out.String())
+	}
+	if got := counter.bytes(); got != 2048 {
+		t.Errorf("bytes() = %d, want 2048 for the one pushed layer", got)
+	}
+	if counter.digest != digest {
+		t.Errorf("digest = %q, want %q", counter.digest, digest)
+	}
+}
+
+func TestCopyStreamError(t *testing.T) {
+	stream := `{"status":"Preparing","id":"layer-a"}` + "\n" +
+		`{"errorDetail":{"message":"denied: requested access to the resource is denied"},"error":"denied: requested access to the resource is denied"}` + "\n" +
+		`{"status":"never read"}` + "\n"
+	var observed []string
+	var out bytes.Buffer
+	err := copyStream(&out, strings.NewReader(stream), func(msg *jsonmessage.JSONMessage) {
+		observed = append(observed, msg.Status)
+	})
+	if err == nil || err.Error() != "denied: requested access to the resource is denied" {
+		t.Fatalf("copyStream error = %v, want the stream error", err)
+	}
+	if len(observed) != 1 || strings.Contains(out.String(), "never read") {
+		t.Errorf("copyStream continued after the error: observed %v, output %q", observed, out.String())
+	}
+}
+
+func TestIsImmutableTag(t *testing.T) {
+	tests := []struct {
+		message string
+		want    bool
+	}{
+		// Harbor
+		{"unknown: Failed to process request due to 'library/nginx:1.25' configured as immutable.", true},
+		// Amazon ECR
+		{"tag invalid: The image tag '1.25' already exists in the 'nginx' repository and cannot be overwritten because the repository is immutable.", true},
+		{"denied: requested access to the resource is denied", false},
+		{"denied: requested access to the resource is denied: user/immutable-tools", false},
+		{"unauthorized: authentication required for team/immutable", false},
+		{"unknown: blob upload unknown", false},
+	}
+	for _, tt := range tests {
+		if got := isImmutableTag(errors.New(tt.message)); got != tt.want {
+			t.Errorf("isImmutableTag(%q) = %v, want %v", tt.message, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// 镜像转换状态
+const (
+	statusSuccess = "success"
+	statusSkipped = "skipped"
+	statusFailed  = "failed"
+	// statusTooLarge 超过 --max-image-size 被跳过，汇总时计入 skipped
+	statusTooLarge = "skipped-too-large"
+)
+
+// mirrorResult 单个镜像的转换结果
+type mirrorResult struct {
+	Source string
+	Target string
+	Status string
+	// Size 镜像大小（字节）
+	Size int64
+	// Error 失败原因
+	Error string
+}
+
+// summary 转换结果汇总
+type summary struct {
+	Total     int `json:"total"`
+	Succeeded int `json:"succeeded"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+	// SkippedTooLarge 因超过 --max-image-size 跳过的数量，已计入 Skipped
+	SkippedTooLarge int   `json:"skipped_too_large"`
+	TotalBytes      int64 `json:"total_bytes"`
+	// PushedBytes 实际上传的字节数，不含仓库中已存在的层
+	PushedBytes int64  `json:"pushed_bytes"`
+	DurationMs  int64  `json:"duration_ms"`
+	Status      string `json:"status"`
+	// Metadata 生成汇总的程序构建信息
+	Metadata buildInfo `json:"metadata"`
+}
+
+// summarize 根据每个镜像的结果计算汇总
+func summarize(results []mirrorResult, duration time.Duration) summary {
+	s := summary{
+		Total:      len(results),
+		DurationMs: duration.Milliseconds(),
+		Metadata:   currentBuild(),
+	}
+	for _, result := range results {
+		switch result.Status {
+		case statusSuccess:
+			s.Succeeded++
+			s.TotalBytes += result.Size
+		case statusSkipped:
+			s.Skipped++
+		case statusTooLarge:
+			s.Skipped++
+			s.SkippedTooLarge++
+		case statusFailed:
+			s.Failed++
+		}
+	}
+	// 跳过的镜像不算失败：没有失败即为成功，全部失败才是失败
+	switch {
+	case s.Failed == 0:
+		s.Status = statusSuccess
+	case s.Failed == s.Total:
+		s.Status = statusFailed
+	default:
+		s.Status = "partial"
+	}
+	return s
+}
+
+// writeSummary 将汇总写入 JSON 文件
+func writeSummary(file string, s summary) {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	err = os.WriteFile(file, data, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// tooManyErrors 判断失败的镜像数是否超过 --max-errors，需要以非 0 退出，maxErrors 为负数时不限制
+func tooManyErrors(failed, maxErrors int) bool {
+	return maxErrors >= 0 && failed > maxErrors
+}
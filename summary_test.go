@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	result := func(status string, size int64) mirrorResult {
+		return mirrorResult{Source: "nginx", Status: status, Size: size}
+	}
+	tests := []struct {
+		name    string
+		results []mirrorResult
+		want    summary
+	}{
+		{"empty", nil, summary{Status: statusSuccess}},
+		{"all succeeded", []mirrorResult{result(statusSuccess, 10), result(statusSuccess, 20)}, summary{Total: 2, Succeeded: 2, TotalBytes: 30, Status: statusSuccess}},
+		{"all skipped", []mirrorResult{result(statusSkipped, 0), result(statusTooLarge, 0)}, summary{Total: 2, Skipped: 2, SkippedTooLarge: 1, Status: statusSuccess}},
+		{"succeeded and skipped", []mirrorResult{result(statusSuccess, 10), result(statusSkipped, 0)}, summary{Total: 2, Succeeded: 1, Skipped: 1, TotalBytes: 10, Status: statusSuccess}},
+		{"mixed", []mirrorResult{result(statusSuccess, 10), result(statusSkipped, 0), result(statusTooLarge, 0), result(statusFailed, 5)}, summary{Total: 4, Succeeded: 1, Skipped: 2, SkippedTooLarge: 1, Failed: 1, TotalBytes: 10, Status: "partial"}},
+		{"skipped and failed", []mirrorResult{result(statusSkipped, 0), result(statusFailed, 0)}, summary{Total: 2, Skipped: 1, Failed: 1, Status: "partial"}},
+		{"all failed", []mirrorResult{result(statusFailed, 0), result(statusFailed, 0)}, summary{Total: 2, Failed: 2, Status: statusFailed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := summarize(tt.results, 1500*time.Millisecond)
+			tt.want.DurationMs = 1500
+			tt.want.Metadata = currentBuild()
+			if got != tt.want {
+				t.Errorf("summarize = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTooManyErrors(t *testing.T) {
+	tests := []struct {
+		failed, maxErrors int
+		want              bool
+	}{
+		{0, 0, false},
+		{1, 0, true},
+		{2, 3, false},
+		{3, 3, false},
+		{4, 3, true},
+		{0, -1, false},
+		{100, -1, false},
+	}
+	for _, tt := range tests {
+		if got := tooManyErrors(tt.failed, tt.maxErrors); got != tt.want {
+			t.Errorf("tooManyErrors(%d, %d) = %v, want %v", tt.failed, tt.maxErrors, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// tagFilter 按 --tag-allow 和 --tag-deny 过滤 tag：设置 allow 时只保留匹配的 tag，
+// 匹配 deny 的 tag 总是被排除
+type tagFilter struct {
+	allow *regexp.Regexp
+	deny  *regexp.Regexp
+}
+
+func newTagFilter(allow, deny string) tagFilter {
+	var f tagFilter
+	if allow != "" {
+		f.allow = regexp.MustCompile(allow)
+	}
+	if deny != "" {
+		f.deny = regexp.MustCompile(deny)
+	}
+	return f
+}
+
+// match 判断 tag 是否可以转换
+func (f tagFilter) match(tag string) bool {
+	if f.allow != nil && !f.allow.MatchString(tag) {
+		return false
+	}
+	return f.deny == nil || !f.deny.MatchString(tag)
+}
+
+// expandTags 将仓库展开为其所有通过 filter 的 tag 对应的镜像，最多 max 个
+func expandTags(ctx context.Context, rc *registryClient, repo string, max int, filter tagFilter) []string {
+	all, err := rc.listTags(ctx, repo)
+	if err != nil {
+		panic(err)
+	}
+	tags := make([]string, 0, len(all))
+	for _, tag := range all {
+		if filter.match(tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) < len(all) {
+		fmt.Println(repo, "共有", len(all), "个 tag，过滤后剩余", len(tags), "个")
+	}
+	if len(tags) > max {
+		fmt.Println("警告：", repo, "共有", len(tags), "个 tag，只转换前", max, "个")
+		tags = tags[:max]
+	}
+	images := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		images = append(images, repo+":"+tag)
+	}
+	return images
+}
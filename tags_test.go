@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTagFilter(t *testing.T) {
+	tags := []string{"1.25.0", "1.26.0-rc1", "1.26.0-rc.2", "2.0.0-alpha", "2.0.0-alpha.1", "2.0.0-beta", "latest", "v3.0.0"}
+	tests := []struct {
+		name, allow, deny string
+		want              []string
+	}{
+		{"no filter", "", "", tags},
+		{"deny rc", "", `-rc`, []string{"1.25.0", "2.0.0-alpha", "2.0.0-alpha.1", "2.0.0-beta", "latest", "v3.0.0"}},
+		{"deny pre-release", "", `-(rc|alpha|beta)`, []string{"1.25.0", "latest", "v3.0.0"}},
+		{"allow semver", `^v?\d+\.\d+\.\d+$`, "", []string{"1.25.0", "v3.0.0"}},
+		{"allow and deny", `^\d`, `alpha`, []string{"1.25.0", "1.26.0-rc1", "1.26.0-rc.2", "2.0.0-beta"}},
+		{"deny wins", `^1\.`, `^1\.`, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTagFilter(tt.allow, tt.deny)
+			got := make([]string, 0)
+			for _, tag := range tags {
+				if f.match(tag) {
+					got = append(got, tag)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("match = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandTags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]string{"tags": {"1.0", "1.1-rc1", "1.1", "2.0-alpha", "2.0"}})
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	rc := newRegistryClient(nil, []string{host})
+	repo := host + "/library/app"
+
+	tests := []struct {
+		name   string
+		max    int
+		filter tagFilter
+		want   []string
+	}{
+		{"all", 10, tagFilter{}, []string{repo + ":1.0", repo + ":1.1-rc1", repo + ":1.1", repo + ":2.0-alpha", repo + ":2.0"}},
+		{"deny pre-release", 10, newTagFilter("", `-(rc|alpha)`), []string{repo + ":1.0", repo + ":1.1", repo + ":2.0"}},
+		{"max after filter", 2, newTagFilter("", `-(rc|alpha)`), []string{repo + ":1.0", repo + ":1.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expandTags(context.Background(), rc, repo, tt.max, tt.filter); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandTags = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+)
+
+// normalizeRegistry 去除自定义仓库地址中的协议和末尾的 /
+func normalizeRegistry(registry string) string {
+	registry = strings.TrimPrefix(registry, "https://")
+	registry = strings.TrimPrefix(registry, "http://")
+	return strings.TrimRight(registry, "/")
+}
+
+// resolveCustomRegistry 返回去除协议后的自定义仓库地址，--customRegistry 优先于内容中的 custom-registry；
+// required 为 true（--require-custom-registry）时两者都未设置则报错
+func resolveCustomRegistry(contentRegistry, flagRegistry string, required bool) string {
+	registry := contentRegistry
+	if flagRegistry != "" {
+		registry = flagRegistry
+	}
+	registry = normalizeRegistry(registry)
+	if required && registry == "" {
+		panic("custom-registry is required by --require-custom-registry.")
+	}
+	return registry
+}
+
+// validateContent 检查原始镜像内容，返回发现的所有问题，不访问 Docker 和镜像仓库；
+// repo:* 和 --all-tags 只检查仓库名，展开后的数量需转换时才能确定
+func validateContent(content mirrorContent, allTags []string, maxContent int) []string {
+	problems := make([]string, 0)
+	count := 0
+	for i, entry := range content.Content {
+		if entry.Image == "" {
+			problems = append(problems, fmt.Sprintf("第 %d 个镜像为空", i+1))
+			continue
+		}
+		count++
+		image := entry.Image
+		if repo, tag := splitTag(image); tag == "*" {
+			image = repo
+		}
+		if _, err := reference.ParseNormalizedNamed(image); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", entry.Image, err))
+		}
+		if entry.ExpectedDigest != "" {
+			if _, err := reference.ParseNormalizedNamed("hub-mirror@" + entry.ExpectedDigest); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid expected-digest %s: %v", entry.Image, entry.ExpectedDigest, err))
+			}
+		}
+		if entry.Retries != nil && *entry.Retries < 0 {
+			problems = append(problems, fmt.Sprintf("%s: retries cannot be negative", entry.Image))
+		}
+		if entry.Timeout != "" {
+			if _, err := time.ParseDuration(entry.Timeout); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid timeout %s: %v", entry.Image, entry.Timeout, err))
+			}
+		}
+	}
+	for _, repo := range allTags {
+		count++
+		if _, err := reference.ParseNormalizedNamed(repo); err != nil {
+			problems = append(problems, fmt.Sprintf("--all-tags %s: %v", repo, err))
+		}
+	}
+	if count > maxContent {
+		problems = append(problems, fmt.Sprintf("共 %d 个镜像，超过 maxContent %d", count, maxContent))
+	}
+	if content.CustomRegistry != "" {
+		if _, err := reference.ParseNormalizedNamed(content.CustomRegistry + "/hub-mirror"); err != nil || (!strings.ContainsAny(content.CustomRegistry, ".:") && content.CustomRegistry != "localhost") {
+			problems = append(problems, fmt.Sprintf("invalid custom-registry %s", content.CustomRegistry))
+		}
+	}
+	return problems
+}
+
+// unpinnedImages 返回未通过 @sha256: 固定 digest 的镜像，repo:* 和 --all-tags 展开的 tag 都是可变的，同样不允许
+func unpinnedImages(content mirrorContent, allTags []string) []string {
+	problems := make([]string, 0)
+	for i, entry := range content.Content {
+		if entry.Image == "" {
+			continue
+		}
+		if _, tag := splitTag(entry.Image); tag == "*" {
+			problems = append(problems, fmt.Sprintf("第 %d 个镜像 %s 展开的 tag 未固定 digest", i+1, entry.Image))
+			continue
+		}
+		if _, digest := splitDigest(entry.Image); !strings.HasPrefix(digest, "sha256:") {
+			problems = append(problems, fmt.Sprintf("第 %d 个镜像 %s 未固定 digest，需写作 %s@sha256:...", i+1, entry.Image, entry.Image))
+		}
+	}
+	for _, repo := range allTags {
+		problems = append(problems, fmt.Sprintf("--all-tags %s 展开的 tag 未固定 digest", repo))
+	}
+	return problems
+}
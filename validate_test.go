@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// parseTestContent 解析 JSON 格式的镜像内容
+func parseTestContent(t *testing.T, text string) mirrorContent {
+	t.Helper()
+	var c mirrorContent
+	if err := json.Unmarshal([]byte(text), &c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestValidateContent(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		allTags    []string
+		maxContent int
+		// want 每个问题应包含的内容，顺序与返回的问题一致
+		want []string
+	}{
+		{"valid", `{"hub-mirror":["nginx:1.25","gcr.io/pause:3.9",{"image":"redis:7","retries":2,"timeout":"5m"}],"custom-registry":"registry.example.com"}`, []string{"alpine"}, 4, nil},
+		{"empty image", `{"hub-mirror":["nginx",""]}`, nil, 10, []string{"第 2 个镜像为空"}},
+		{"invalid name", `{"hub-mirror":["Nginx:1.25","nginx:bad tag"]}`, nil, 10, []string{"Nginx:1.25", "nginx:bad tag"}},
+		{"repo wildcard", `{"hub-mirror":["nginx:*"]}`, nil, 10, nil},
+		{"expected digest", `{"hub-mirror":[{"image":"nginx","expected-digest":"sha256:123"}]}`, nil, 10, []string{"invalid expected-digest sha256:123"}},
+		{"negative retries", `{"hub-mirror":[{"image":"nginx","retries":-1}]}`, nil, 10, []string{"retries cannot be negative"}},
+		{"invalid timeout", `{"hub-mirror":[{"image":"nginx","timeout":"ten minutes"}]}`, nil, 10, []string{"invalid timeout ten minutes"}},
+		{"invalid all-tags", `{"hub-mirror":[]}`, []string{"Bad/Repo"}, 10, []string{"--all-tags Bad/Repo"}},
+		{"max content", `{"hub-mirror":["nginx","redis"]}`, []string{"alpine"}, 2, []string{"共 3 个镜像，超过 maxContent 2"}},
+		{"empty not counted", `{"hub-mirror":["nginx",""]}`, nil, 1, []string{"第 2 个镜像为空"}},
+		{"custom registry without host", `{"hub-mirror":["nginx"],"custom-registry":"myregistry"}`, nil, 10, []string{"invalid custom-registry myregistry"}},
+		{"custom registry localhost", `{"hub-mirror":["nginx"],"custom-registry":"localhost"}`, nil, 10, nil},
+		{"custom registry port", `{"hub-mirror":["nginx"],"custom-registry":"myregistry:5000"}`, nil, 10, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validateContent(parseTestContent(t, tt.content), tt.allTags, tt.maxContent)
+			if len(got) != len(tt.want) {
+				t.Fatalf("validateContent = %q, want %d problems", got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i], want) {
+					t.Errorf("problem %d = %q, want it to contain %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestUnpinnedImages(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		allTags []string
+		want    []string
+	}{
+		{"pinned", `{"hub-mirror":["nginx@` + testDigest + `","nginx:1.25@` + testDigest + `",""]}`, nil, nil},
+		{"tag only", `{"hub-mirror":["nginx@` + testDigest + `","redis:7"]}`, nil, []string{"第 2 个镜像 redis:7 未固定 digest，需写作 redis:7@sha256:..."}},
+		{"no tag", `{"hub-mirror":["redis"]}`, nil, []string{"第 1 个镜像 redis 未固定 digest"}},
+		{"object entry", `{"hub-mirror":[{"image":"redis:7","expected-digest":"` + testDigest + `"}]}`, nil, []string{"redis:7 未固定 digest"}},
+		{"repo wildcard", `{"hub-mirror":["nginx:*"]}`, nil, []string{"第 1 个镜像 nginx:* 展开的 tag 未固定 digest"}},
+		{"all-tags", `{"hub-mirror":[]}`, []string{"alpine"}, []string{"--all-tags alpine 展开的 tag 未固定 digest"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unpinnedImages(parseTestContent(t, tt.content), tt.allTags)
+			if len(got) != len(tt.want) {
+				t.Fatalf("unpinnedImages = %q, want %d problems", got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i], want) {
+					t.Errorf("problem %d = %q, want it to contain %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveCustomRegistry(t *testing.T) {
+	tests := []struct {
+		content, flag string
+		required      bool
+		want          string
+	}{
+		{"", "", false, ""},
+		{"https://content.example.com/", "", false, "content.example.com"},
+		{"content.example.com", "http://flag.example.com", false, "flag.example.com"},
+		{"content.example.com", "", true, "content.example.com"},
+		{"", "flag.example.com", true, "flag.example.com"},
+	}
+	for _, tt := range tests {
+		if got := resolveCustomRegistry(tt.content, tt.flag, tt.required); got != tt.want {
+			t.Errorf("resolveCustomRegistry(%q, %q, %v) = %q, want %q", tt.content, tt.flag, tt.required, got, tt.want)
+		}
+	}
+	// 只有协议或 / 也视为未设置
+	for _, registry := range []string{"", "https://", "/"} {
+		message := mustPanic(t, func() { resolveCustomRegistry(registry, "", true) })
+		if !strings.Contains(message, "--require-custom-registry") {
+			t.Errorf("resolveCustomRegistry(%q) panic = %q, want the --require-custom-registry error", registry, message)
+		}
+	}
+}
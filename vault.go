@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// readVaultCredentials 从 Vault KV 读取认证信息，secret 中每个键为仓库地址，值为
+// { "username": "", "password": "", "token": "" }，同时支持 KV v1 和 v2
+func readVaultCredentials(addr, path, token string) map[string]registryCredential {
+	if token == "" {
+		panic("VAULT_TOKEN is required by --vault-path.")
+	}
+	path = strings.Trim(path, "/")
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+path, nil)
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		panic(fmt.Sprintf("read vault secret %s: %s", path, resp.Status))
+	}
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		panic(err)
+	}
+	data := body.Data
+	// KV v2 的 secret 位于 data.data，同时带有 data.metadata
+	if _, ok := data["metadata"]; ok {
+		var inner map[string]json.RawMessage
+		err = json.Unmarshal(data["data"], &inner)
+		if err != nil {
+			panic(err)
+		}
+		data = inner
+	}
+	credentials := make(map[string]registryCredential, len(data))
+	for host, value := range data {
+		var credential registryCredential
+		err = json.Unmarshal(value, &credential)
+		if err != nil {
+			panic(fmt.Sprintf("vault secret %s: invalid credential for %s: %v", path, host, err))
+		}
+		credentials[normalizeHost(host)] = credential
+	}
+	return credentials
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newVaultServer 模拟 Vault HTTP API，只接受 token 为 vault-test-token 的请求
+func newVaultServer(t *testing.T, secrets map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("X-Vault-Token") != "vault-test-token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		body, ok := secrets[r.URL.Path]
+		if !ok {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestReadVaultCredentials(t *testing.T) {
+	server := newVaultServer(t, map[string]string{
+		"/v1/secret/hub-mirror": `{"data": {
+			"index.docker.io": {"username": "hub-user", "password": "hub-pass"},
+			"ghcr.io": {"token": "gh-token"}
+		}}`,
+		"/v1/secret/data/hub-mirror": `{"data": {
+			"data": {
+				"docker.io": {"username": "hub-user", "password": "hub-pass"},
+				"ghcr.io": {"token": "gh-token"}
+			},
+			"metadata": {"version": 3}
+		}}`,
+		"/v1/secret/invalid": `{"data": {"ghcr.io": "not an object"}}`,
+	})
+	want := map[string]registryCredential{
+		"docker.io": {Username: "hub-user", Password: "hub-pass"},
+		"ghcr.io":   {Token: "gh-token"},
+	}
+	tests := []struct {
+		name string
+		addr string
+		path string
+	}{
+		{"kv v1", server.URL, "secret/hub-mirror"},
+		{"kv v2", server.URL, "secret/data/hub-mirror"},
+		{"slashes", server.URL + "/", "/secret/data/hub-mirror/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readVaultCredentials(tt.addr, tt.path, "vault-test-token")
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("readVaultCredentials() = %#v, want %#v", got, want)
+			}
+		})
+	}
+
+	errors := []struct {
+		name, path, token, want string
+	}{
+		{"forbidden", "secret/hub-mirror", "wrong-token", "403 Forbidden"},
+		{"not found", "secret/missing", "vault-test-token", "404 Not Found"},
+		{"invalid credential", "secret/invalid", "vault-test-token", "invalid credential for ghcr.io"},
+		{"no token", "secret/hub-mirror", "", "VAULT_TOKEN is required"},
+	}
+	for _, tt := range errors {
+		t.Run(tt.name, func(t *testing.T) {
+			message := mustPanic(t, func() { readVaultCredentials(server.URL, tt.path, tt.token) })
+			if !strings.Contains(message, tt.want) {
+				t.Errorf("panic = %q, want it to mention %q", message, tt.want)
+			}
+		})
+	}
+}
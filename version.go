@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// 构建信息，通过 -ldflags "-X main.version=v1.0.0 -X main.commit=... -X main.buildDate=..." 注入
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// buildInfo 当前程序的构建信息
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+}
+
+// String 返回 --version 打印的内容
+func (b buildInfo) String() string {
+	return fmt.Sprintf("hub-mirror %s (commit %s, built %s)", b.Version, b.Commit, b.BuildDate)
+}
+
+// currentBuild 返回构建信息，未通过 -ldflags 注入时使用 Go 记录的构建信息：
+// go install module@version 的模块版本，以及 Go 1.18 起在仓库中构建时的 vcs 提交和时间
+func currentBuild() buildInfo {
+	bi, _ := debug.ReadBuildInfo()
+	return resolveBuild(buildInfo{Version: version, Commit: commit, BuildDate: buildDate}, bi)
+}
+
+// resolveBuild 用 Go 记录的构建信息 bi（可为 nil）补全 info 中未通过 -ldflags 注入的字段
+func resolveBuild(info buildInfo, bi *debug.BuildInfo) buildInfo {
+	if bi == nil {
+		return info
+	}
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	revision, date := vcsInfo(bi)
+	if info.Commit == "unknown" && revision != "" {
+		info.Commit = revision
+	}
+	if info.BuildDate == "unknown" && date != "" {
+		info.BuildDate = date
+	}
+	return info
+}
@@ -0,0 +1,11 @@
+//go:build !go1.18
+// +build !go1.18
+
+package main
+
+import "runtime/debug"
+
+// vcsInfo Go 1.18 之前的构建信息不包含 vcs 信息
+func vcsInfo(bi *debug.BuildInfo) (revision, date string) {
+	return "", ""
+}
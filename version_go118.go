@@ -0,0 +1,19 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import "runtime/debug"
+
+// vcsInfo 返回构建时记录的 vcs.revision 和 vcs.time
+func vcsInfo(bi *debug.BuildInfo) (revision, date string) {
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.time":
+			date = s.Value
+		}
+	}
+	return revision, date
+}
@@ -0,0 +1,30 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestResolveBuildVCS(t *testing.T) {
+	bi := &debug.BuildInfo{
+		Main: debug.Module{Version: "(devel)"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs", Value: "git"},
+			{Key: "vcs.revision", Value: "0123456789abcdef"},
+			{Key: "vcs.time", Value: "2026-09-30T12:00:00Z"},
+			{Key: "vcs.modified", Value: "false"},
+		},
+	}
+	got := resolveBuild(buildInfo{Version: "dev", Commit: "unknown", BuildDate: "unknown"}, bi)
+	if want := (buildInfo{Version: "dev", Commit: "0123456789abcdef", BuildDate: "2026-09-30T12:00:00Z"}); got != want {
+		t.Errorf("resolveBuild() = %+v, want %+v", got, want)
+	}
+	// -ldflags 注入的值优先于 vcs 信息
+	injected := buildInfo{Version: "v1.2.3", Commit: "abc1234", BuildDate: "2026-10-01T00:00:00Z"}
+	if got := resolveBuild(injected, bi); got != injected {
+		t.Errorf("resolveBuild() = %+v, want the injected %+v", got, injected)
+	}
+}
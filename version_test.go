@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestResolveBuild(t *testing.T) {
+	defaults := buildInfo{Version: "dev", Commit: "unknown", BuildDate: "unknown"}
+	injected := buildInfo{Version: "v1.2.3", Commit: "abc1234", BuildDate: "2026-10-01T00:00:00Z"}
+	tests := []struct {
+		name string
+		info buildInfo
+		bi   *debug.BuildInfo
+		want buildInfo
+	}{
+		{"no build info", defaults, nil, defaults},
+		{"ldflags", injected, &debug.BuildInfo{Main: debug.Module{Version: "v0.9.0"}}, injected},
+		{"go install", defaults, &debug.BuildInfo{Main: debug.Module{Version: "v0.9.0"}}, buildInfo{Version: "v0.9.0", Commit: "unknown", BuildDate: "unknown"}},
+		{"devel", defaults, &debug.BuildInfo{Main: debug.Module{Version: "(devel)"}}, defaults},
+	}
+	for _, tt := range tests {
+		if got := resolveBuild(tt.info, tt.bi); got != tt.want {
+			t.Errorf("%s: resolveBuild() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildInfoString(t *testing.T) {
+	b := buildInfo{Version: "v1.2.3", Commit: "abc1234", BuildDate: "2026-10-01T00:00:00Z"}
+	if got, want := b.String(), "hub-mirror v1.2.3 (commit abc1234, built 2026-10-01T00:00:00Z)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+// TestVersionFlag 构建程序并检查 --version 打印 -ldflags 注入的值
+func TestVersionFlag(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the binary")
+	}
+	bin := filepath.Join(t.TempDir(), "hub-mirror")
+	ldflags := "-X main.version=v9.8.7 -X main.commit=deadbee -X main.buildDate=2026-10-14T00:00:00Z"
+	if out, err := exec.Command("go", "build", "-ldflags", ldflags, "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	out, err := exec.Command(bin, "--version").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(out)), "hub-mirror v9.8.7 (commit deadbee, built 2026-10-14T00:00:00Z)"; got != want {
+		t.Errorf("--version = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// watchExcluded 由每轮转换单独指定、不从 --watch 进程继承的参数，
+// 映射、脚本、汇总等输出由 --watch 进程合并各轮结果后写出
+var watchExcluded = map[string]bool{
+	"watch": true, "password": true, "header": true, "confirm": true,
+	"content": true, "contentFile": true, "format": true, "content-header": true, "retry-file": true,
+	"merge-content": true, "all-tags": true, "content-from-helm": true, "helm-values": true, "content-from-k8s": true,
+	"log-file": true, "log-max-size": true,
+	"outputPath": true, "customRegistryPath": true, "nerdctlPath": true, "makefile-output": true, "ansible-output": true,
+	"no-scripts": true, "mapping-json": true, "digest-map-output": true, "base-mapping": true,
+	"summary-json": true, "failures-file": true,
+}
+
+// watchPlans 持续转换，直到收到 SIGINT/SIGTERM：第一轮转换所有镜像，之后每隔 interval
+// 按 --check-digest-drift 的方式检查源镜像 tag 的 digest，只重新转换发生变化的镜像。
+// 每轮以当前参数重新执行本程序完成转换，失败的镜像在下一轮重试。固定 digest 的源镜像不会变化，
+// 转换成功后不再转换。每轮结束后合并所有已转换的镜像写出映射和脚本，汇总和失败文件为最近一轮的结果
+func watchPlans(interval time.Duration, rc *registryClient, plans []mirrorOutput, customRegistry string, defaultRetries int, style scriptStyle) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	args := inheritedArgs(pflag.CommandLine, watchExcluded)
+	dir, err := os.MkdirTemp("", "hub-mirror-watch-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	cycleFiles := &watchFiles{
+		content:  filepath.Join(dir, "content.json"),
+		mapping:  filepath.Join(dir, "mapping.json"),
+		digests:  filepath.Join(dir, "digests.json"),
+		summary:  filepath.Join(dir, "summary.json"),
+		failures: filepath.Join(dir, "failures.json"),
+	}
+
+	// digests 上一次成功转换时源镜像的 digest
+	digests := make(map[string]string)
+	// mirrored 各轮已转换的镜像，key 为源镜像
+	mirrored := make(map[string]mirrorOutput)
+	for cycle := 1; ; cycle++ {
+		pending, current := watchCycle(ctx, rc, plans, digests, cycle == 1)
+		if ctx.Err() != nil {
+			break
+		}
+		if len(pending) == 0 {
+			fmt.Println("第", cycle, "轮：源镜像 digest 均未变化")
+		} else {
+			fmt.Println("第", cycle, "轮：转换", len(pending), "个镜像")
+			c := mirrorContent{CustomRegistry: customRegistry}
+			for _, plan := range pending {
+				c.Content = append(c.Content, planEntry(plan, defaultRetries))
+			}
+			cycleFiles.clean()
+			writeContent(cycleFiles.content, c)
+			err := runChild(ctx, append(cycleFiles.args(), args...))
+			if err != nil {
+				fmt.Println("第", cycle, "轮转换失败，下一轮重试：", redact(err.Error()))
+			}
+			for _, plan := range cycleFiles.merge(pending, mirrored, err == nil) {
+				digests[plan.Source] = current[plan.Source]
+			}
+			cycleFiles.writeOutputs(plans, mirrored, customRegistry, style)
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	fmt.Println("已停止持续转换")
+}
+
+// watchFiles 每轮子进程的原始镜像和输出文件
+type watchFiles struct {
+	content, mapping, digests, summary, failures string
+	// copiedFailures 是否已将某一轮的失败文件复制到 --failures-file
+	copiedFailures bool
+}
+
+// args 返回子进程的输入输出参数，脚本由 --watch 进程合并后生成
+func (f watchFiles) args() []string {
+	return []string{
+		"--contentFile=" + f.content, "--format=json", "--no-scripts",
+		"--mapping-json=" + f.mapping, "--digest-map-output=" + f.digests,
+		"--summary-json=" + f.summary, "--failures-file=" + f.failures,
+	}
+}
+
+// clean 删除上一轮的输出，避免将其当作本轮结果
+func (f watchFiles) clean() {
+	for _, file := range []string{f.mapping, f.digests, f.summary, f.failures} {
+		os.Remove(file)
+	}
+}
+
+// merge 将本轮映射中的镜像合并到 mirrored，返回本轮已完成（转换成功或跳过）的镜像：
+// 不在失败文件中的镜像视为完成，子进程异常退出且没有写出失败文件时均视为未完成
+func (f watchFiles) merge(pending []mirrorOutput, mirrored map[string]mirrorOutput, exited bool) []mirrorOutput {
+	pushed := make(map[string]string)
+	if _, err := os.Stat(f.digests); err == nil {
+		for _, entry := range readDigestMap(f.digests) {
+			pushed[entry.Source] = entry.PushedDigest
+		}
+	}
+	if _, err := os.Stat(f.mapping); err == nil {
+		plans := make(map[string]mirrorOutput, len(pending))
+		for _, plan := range pending {
+			plans[plan.Source] = plan
+		}
+		for _, entry := range readMapping(f.mapping) {
+			plan, ok := plans[entry.Source]
+			if !ok {
+				continue
+			}
+			plan.Target, plan.Digest, plan.PushedDigest = entry.Target, entry.Digest, pushed[entry.Source]
+			plan.PullMs, plan.TagMs, plan.PushMs = entry.PullMs, entry.TagMs, entry.PushMs
+			mirrored[entry.Source] = plan
+		}
+	}
+
+	failed := make(map[string]bool)
+	if _, err := os.Stat(f.failures); err == nil {
+		for _, entry := range readContentFile(f.failures, "json", nil).Content {
+			failed[entry.Image] = true
+		}
+	} else if !exited {
+		return nil
+	}
+	done := make([]mirrorOutput, 0, len(pending))
+	for _, plan := range pending {
+		if !failed[plan.Image] {
+			done = append(done, plan)
+		}
+	}
+	return done
+}
+
+// writeOutputs 按原始镜像的顺序写出所有已转换镜像的映射和脚本，并复制最近一轮的汇总和失败文件，
+// 最近一轮没有失败时删除之前复制的失败文件
+func (f *watchFiles) writeOutputs(plans []mirrorOutput, mirrored map[string]mirrorOutput, customRegistry string, style scriptStyle) {
+	output := make([]mirrorOutput, 0, len(mirrored))
+	for _, plan := range plans {
+		if o, ok := mirrored[plan.Source]; ok {
+			output = append(output, o)
+		}
+	}
+	if len(output) > 0 {
+		writeOutputs(output, customRegistry, style)
+	}
+	if *summaryJSON != "" {
+		copyFile(f.summary, *summaryJSON)
+	}
+	if *failuresFile != "" {
+		if _, err := os.Stat(f.failures); err == nil {
+			copyFile(f.failures, *failuresFile)
+			f.copiedFailures = true
+		} else if f.copiedFailures {
+			os.Remove(*failuresFile)
+			f.copiedFailures = false
+		}
+	}
+}
+
+// copyFile 复制文件，src 不存在时不做任何事
+func copyFile(src, dst string) {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return
+	}
+	err = os.WriteFile(dst, data, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// watchCycle 检查源镜像 tag 的 digest，返回本轮需要转换的镜像和各源镜像当前的 digest：
+// 第一轮转换所有镜像，之后只转换 digest 与 digests 中上一次成功转换时不同的镜像，检查失败的镜像不转换
+func watchCycle(ctx context.Context, rc *registryClient, plans []mirrorOutput, digests map[string]string, first bool) ([]mirrorOutput, map[string]string) {
+	entries := checkDrift(ctx, rc, plans, digestBaseline(digests))
+	current := make(map[string]string, len(entries)+len(plans))
+	for _, plan := range plans {
+		if _, d := splitDigest(plan.Pull); d != "" {
+			current[plan.Source] = d
+		}
+	}
+	for _, entry := range entries {
+		switch entry.Status {
+		case driftChanged:
+			fmt.Println("[漂移]", entry.Source, entry.Baseline, "=>", entry.Current)
+		case driftError:
+			fmt.Println("[失败]", entry.Source+":", entry.Error)
+			continue
+		}
+		current[entry.Source] = entry.Current
+	}
+	if first {
+		return plans, current
+	}
+	pending := make([]mirrorOutput, 0)
+	for _, plan := range plans {
+		if d, ok := current[plan.Source]; ok && d != digests[plan.Source] {
+			pending = append(pending, plan)
+		}
+	}
+	return pending, current
+}
+
+// digestBaseline 将上一次转换的 digest 转换为 checkDrift 的基准
+func digestBaseline(digests map[string]string) []mappingEntry {
+	base := make([]mappingEntry, 0, len(digests))
+	for source, digest := range digests {
+		base = append(base, mappingEntry{Source: source, Digest: digest})
+	}
+	return base
+}
+
+// runChild 以 args 重新执行本程序并等待结束，ctx 取消时向子进程转发 SIGTERM，
+// 由子进程按 --grace-period 完成进行中的上传
+func runChild(ctx context.Context, args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, args...)
+	cmd.Env = childEnv()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if cmd.Process.Signal(syscall.SIGTERM) != nil {
+			cmd.Process.Kill()
+		}
+		return <-done
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testManifest 返回内容为 config 的单平台 manifest
+func testManifest(config string) map[string]interface{} {
+	return map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.docker.distribution.manifest.v2+json",
+		"config":        map[string]interface{}{"mediaType": "application/vnd.docker.container.image.v1+json", "digest": config, "size": 100},
+		"layers":        []interface{}{},
+	}
+}
+
+// sources 返回转换计划的源镜像
+func sources(plans []mirrorOutput) []string {
+	result := make([]string, 0, len(plans))
+	for _, plan := range plans {
+		result = append(result, plan.Source)
+	}
+	return result
+}
+
+func TestWatchCycles(t *testing.T) {
+	f := newFakeRegistry(t, true)
+	app, web := f.host+"/library/app:1.0", f.host+"/library/web:1.0"
+	appDigest := f.setManifest("library/app", "1.0", testManifest("sha256:a1"))
+	webDigest := f.setManifest("library/web", "1.0", testManifest("sha256:b1"))
+	plans := []mirrorOutput{{Pull: app, Source: app}, {Pull: web, Source: web}}
+	rc := f.client()
+	ctx := context.Background()
+
+	// 第一轮转换所有镜像，记录成功转换时的 digest
+	digests := make(map[string]string)
+	pending, current := watchCycle(ctx, rc, plans, digests, true)
+	if got := sources(pending); !reflect.DeepEqual(got, []string{app, web}) {
+		t.Fatalf("cycle 1 pending = %q, want all images", got)
+	}
+	if current[app] != appDigest || current[web] != webDigest {
+		t.Fatalf("cycle 1 digests = %v", current)
+	}
+	for _, plan := range pending {
+		digests[plan.Source] = current[plan.Source]
+	}
+
+	issued := f.issued
+
+	// 第二轮前 app 的 tag 指向新的 manifest，且上一次的 token 已过期
+	newDigest := f.setManifest("library/app", "1.0", testManifest("sha256:a2"))
+	f.revoke()
+	pending, current = watchCycle(ctx, rc, plans, digests, false)
+	if got := sources(pending); !reflect.DeepEqual(got, []string{app}) {
+		t.Fatalf("cycle 2 pending = %q, want only the drifted image", got)
+	}
+	if current[app] != newDigest || current[web] != webDigest {
+		t.Errorf("cycle 2 digests = %v", current)
+	}
+	if f.issued == issued {
+		t.Errorf("no new token issued after the cached token was rejected")
+	}
+	issued = f.issued
+
+	// 没有变化时不转换任何镜像
+	digests[app] = newDigest
+	if pending, _ = watchCycle(ctx, rc, plans, digests, false); len(pending) != 0 {
+		t.Errorf("cycle 3 pending = %q, want none", sources(pending))
+	}
+	if f.issued != issued {
+		t.Errorf("issued %d new tokens while the cached token was still valid", f.issued-issued)
+	}
+}
+
+func TestWatchCycleError(t *testing.T) {
+	f := newFakeRegistry(t, false)
+	missing := f.host + "/library/missing:1.0"
+	plans := []mirrorOutput{{Pull: missing, Source: missing}}
+	pending, current := watchCycle(context.Background(), f.client(), plans, map[string]string{missing: "sha256:old"}, false)
+	if len(pending) != 0 || len(current) != 0 {
+		t.Errorf("pending = %q, current = %v, want failed checks to be retried next cycle", sources(pending), current)
+	}
+}
+
+// setFlag 在测试期间修改参数变量的值
+func setFlag(t *testing.T, p *string, value string) {
+	old := *p
+	*p = value
+	t.Cleanup(func() { *p = old })
+}
+
+// writeJSON 将 v 写入 JSON 文件
+func writeJSON(t *testing.T, file string, v interface{}) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchOutputsMerged(t *testing.T) {
+	dir := t.TempDir()
+	f := &watchFiles{
+		content:  filepath.Join(dir, "content.json"),
+		mapping:  filepath.Join(dir, "cycle-mapping.json"),
+		digests:  filepath.Join(dir, "cycle-digests.json"),
+		summary:  filepath.Join(dir, "cycle-summary.json"),
+		failures: filepath.Join(dir, "cycle-failures.json"),
+	}
+	mapping, script, failures := filepath.Join(dir, "mapping.json"), filepath.Join(dir, "output.sh"), filepath.Join(dir, "failures.json")
+	setFlag(t, mappingJSON, mapping)
+	setFlag(t, outputPath, script)
+	setFlag(t, nerdctlPath, filepath.Join(dir, "nerdctl.sh"))
+	setFlag(t, failuresFile, failures)
+	setFlag(t, baseMapping, "")
+	setFlag(t, restoreTarget, "source")
+
+	plans := []mirrorOutput{
+		{Image: "nginx:1.25", Pull: "nginx:1.25", Source: "nginx:1.25", Target: "user/nginx:1.25"},
+		{Image: "redis:7", Pull: "redis:7", Source: "redis:7", Target: "user/redis:7"},
+		{Image: "alpine:3", Pull: "alpine:3", Source: "alpine:3", Target: "user/alpine:3"},
+	}
+	mirrored := make(map[string]mirrorOutput)
+
+	// 第一轮：nginx、redis 成功，alpine 失败
+	writeJSON(t, f.mapping, []mappingEntry{{Source: "nginx:1.25", Target: "user/nginx:1.25", Digest: "sha256:n1"}, {Source: "redis:7", Target: "user/redis:7", Digest: "sha256:r1"}})
+	writeContent(f.failures, mirrorContent{Content: []contentEntry{{Image: "alpine:3"}}})
+	done := f.merge(plans, mirrored, false)
+	if got := sources(done); !reflect.DeepEqual(got, []string{"nginx:1.25", "redis:7"}) {
+		t.Fatalf("cycle 1 done = %q", got)
+	}
+	f.writeOutputs(plans, mirrored, "", scriptStyles["bash"])
+	if _, err := os.Stat(failures); err != nil {
+		t.Errorf("cycle 1 failures not copied: %v", err)
+	}
+
+	// 第二轮：只转换 digest 变化的 nginx 和上一轮失败的 alpine，映射和脚本仍包含所有镜像
+	f.clean()
+	writeJSON(t, f.mapping, []mappingEntry{{Source: "nginx:1.25", Target: "user/nginx:1.25", Digest: "sha256:n2"}, {Source: "alpine:3", Target: "user/alpine:3", Digest: "sha256:a1"}})
+	writeJSON(t, f.digests, []digestMapEntry{{Source: "nginx:1.25", PushedDigest: "sha256:p2"}})
+	done = f.merge([]mirrorOutput{plans[0], plans[2]}, mirrored, true)
+	if got := sources(done); !reflect.DeepEqual(got, []string{"nginx:1.25", "alpine:3"}) {
+		t.Fatalf("cycle 2 done = %q", got)
+	}
+	f.writeOutputs(plans, mirrored, "", scriptStyles["bash"])
+
+	got := readMapping(mapping)
+	want := []mappingEntry{
+		{Source: "nginx:1.25", Target: "user/nginx:1.25", Digest: "sha256:n2"},
+		{Source: "redis:7", Target: "user/redis:7", Digest: "sha256:r1"},
+		{Source: "alpine:3", Target: "user/alpine:3", Digest: "sha256:a1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mapping = %+v, want %+v", got, want)
+	}
+	data, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, image := range []string{"user/nginx:1.25", "user/redis:7", "user/alpine:3"} {
+		if !strings.Contains(string(data), "docker pull "+image) {
+			t.Errorf("output.sh does not contain %s:\n%s", image, data)
+		}
+	}
+	if mirrored["nginx:1.25"].PushedDigest != "sha256:p2" {
+		t.Errorf("pushed digest = %q, want sha256:p2", mirrored["nginx:1.25"].PushedDigest)
+	}
+	if _, err := os.Stat(failures); !os.IsNotExist(err) {
+		t.Errorf("failures file from cycle 1 still present after a cycle without failures")
+	}
+}
+
+func TestWatchMergeCrashedChild(t *testing.T) {
+	dir := t.TempDir()
+	f := &watchFiles{mapping: filepath.Join(dir, "m.json"), digests: filepath.Join(dir, "d.json"), failures: filepath.Join(dir, "f.json")}
+	plans := []mirrorOutput{{Image: "nginx", Source: "nginx"}}
+	if done := f.merge(plans, make(map[string]mirrorOutput), false); len(done) != 0 {
+		t.Errorf("done = %q, want none when the child failed without a failures file", sources(done))
+	}
+	if done := f.merge(plans, make(map[string]mirrorOutput), true); len(done) != 1 {
+		t.Errorf("done = %q, want all images when the child exited cleanly", sources(done))
+	}
+}